@@ -0,0 +1,62 @@
+package opendkim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// benchBody returns a body of about n bytes in 78 character lines.
+func benchBody(n int) string {
+	line := strings.Repeat("x", 76) + "\r\n"
+	return strings.Repeat(line, n/len(line)+1)
+}
+
+func benchSign(b *testing.B, body string) {
+	lib := Init()
+	defer lib.Close()
+
+	msg := createMsg(msgHdr, body)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+		if stat != StatusOK {
+			b.Fatal(stat)
+		}
+		if _, err := d.Sign(bytes.NewReader(msg)); err != nil {
+			b.Fatal(err)
+		}
+		d.Destroy()
+	}
+}
+
+func BenchmarkSign(b *testing.B) {
+	benchSign(b, benchBody(64<<10))
+}
+
+func BenchmarkSignLarge(b *testing.B) {
+	benchSign(b, benchBody(8<<20))
+}
+
+func BenchmarkVerify(b *testing.B) {
+	lib := Init()
+	defer lib.Close()
+	lib.SetQueryMethodFile("testdata/keys")
+
+	msg := sign(lib, createMsg(msgHdr, benchBody(64<<10)), b)
+	b.SetBytes(int64(len(msg)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			b.Fatal(stat)
+		}
+		if stat = d.Verify(bytes.NewReader(msg)); stat != StatusOK {
+			b.Fatal(stat)
+		}
+		d.Destroy()
+	}
+}
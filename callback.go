@@ -0,0 +1,429 @@
+// +build !windows
+
+package opendkim
+
+/*
+#include <stdlib.h>
+#include <sys/types.h>
+#include <sys/time.h>
+#include <dkim.h>
+
+extern int goDNSStart(void *, int, unsigned char *, unsigned char *, size_t, void **);
+extern int goDNSCancel(void *, void *);
+extern int goDNSWaitReply(void *, void *, struct timeval *, size_t *, int *, int *);
+extern DKIM_CBSTAT goFinal(DKIM *, DKIM_SIGINFO **, int);
+extern DKIM_CBSTAT goPrescreen(DKIM *, DKIM_SIGINFO **, int);
+extern DKIM_CBSTAT goKeyLookup(DKIM *, DKIM_SIGINFO *, unsigned char *, size_t);
+*/
+import "C"
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// The library calls back with its own handles only, so the Go side of
+// a handle is looked up in these registries. A registered Lib stays
+// reachable until it is closed.
+var libs = struct {
+	sync.Mutex
+	m map[*C.DKIM_LIB]*Lib
+}{m: make(map[*C.DKIM_LIB]*Lib)}
+
+func registerLib(lib *Lib) {
+	libs.Lock()
+	defer libs.Unlock()
+
+	libs.m[lib.lib] = lib
+}
+
+func unregisterLib(l *C.DKIM_LIB) {
+	libs.Lock()
+	defer libs.Unlock()
+
+	delete(libs.m, l)
+}
+
+func lookupLib(l *C.DKIM_LIB) *Lib {
+	libs.Lock()
+	defer libs.Unlock()
+
+	return libs.m[l]
+}
+
+// Handles are registered only while a library call that may invoke a
+// callback is running, so they don't outlive their finalizers.
+var handles = struct {
+	sync.Mutex
+	m map[*C.DKIM]*Dkim
+}{m: make(map[*C.DKIM]*Dkim)}
+
+// enter registers d for callbacks. It must be called with d.mtx held
+// and followed by leave.
+func (d *Dkim) enter() {
+	handles.Lock()
+	defer handles.Unlock()
+
+	handles.m[d.dkim] = d
+}
+
+func (d *Dkim) leave() {
+	handles.Lock()
+	defer handles.Unlock()
+
+	delete(handles.m, d.dkim)
+}
+
+func lookupHandle(h *C.DKIM) *Dkim {
+	handles.Lock()
+	defer handles.Unlock()
+
+	return handles.m[h]
+}
+
+// cbStatus maps the status returned by a Go callback to the library's
+// callback status.
+func cbStatus(s Status) C.DKIM_CBSTAT {
+	switch s {
+	case StatusOK:
+		return C.DKIM_CBSTAT_CONTINUE
+	case StatusCBREJECT:
+		return C.DKIM_CBSTAT_REJECT
+	case StatusCBTRYAGAIN:
+		return C.DKIM_CBSTAT_TRYAGAIN
+	default:
+		return C.DKIM_CBSTAT_ERROR
+	}
+}
+
+// sigList wraps the signature array passed to a callback.
+func (d *Dkim) sigList(sigs **C.DKIM_SIGINFO, nsigs C.int) []*Signature {
+	if sigs == nil || nsigs <= 0 {
+		return nil
+	}
+	res := make([]*Signature, 0, int(nsigs))
+	for _, sig := range unsafe.Slice(sigs, int(nsigs)) {
+		res = append(res, &Signature{h: d, sig: sig})
+	}
+	return res
+}
+
+// SetFinal sets a function that is called by Eom once all signatures
+// of a message have been processed. Returning StatusCBREJECT,
+// StatusCBTRYAGAIN or any other status but StatusOK makes Eom fail with
+// the corresponding callback status.
+//
+// The signatures passed to fn are only valid during the call, and fn
+// must not call methods on the handle itself.
+func (lib *Lib) SetFinal(fn func(sigs []*Signature) Status) Status {
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	lib.final = fn
+	if fn == nil {
+		return Status(C.dkim_set_final(lib.lib, nil))
+	}
+	return Status(C.dkim_set_final(lib.lib, (*[0]byte)(C.goFinal)))
+}
+
+//export goFinal
+func goFinal(h *C.DKIM, sigs **C.DKIM_SIGINFO, nsigs C.int) C.DKIM_CBSTAT {
+	d := lookupHandle(h)
+	if d == nil || d.lib == nil {
+		return C.DKIM_CBSTAT_ERROR
+	}
+	d.lib.mtx.Lock()
+	fn := d.lib.final
+	d.lib.mtx.Unlock()
+
+	if fn == nil {
+		return C.DKIM_CBSTAT_CONTINUE
+	}
+	return cbStatus(fn(d.sigList(sigs, nsigs)))
+}
+
+// SetPrescreen sets a function that is called once the signatures of a
+// message have been parsed, before any keys are fetched. fn can drop
+// signatures it won't trust by calling Ignore on them. Any status but
+// StatusOK makes the message fail with the corresponding callback
+// status.
+//
+// The signatures passed to fn are only valid during the call, and fn
+// must not call methods on the handle itself.
+func (lib *Lib) SetPrescreen(fn func(sigs []*Signature) Status) Status {
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	lib.prescreen = fn
+	if fn == nil {
+		return Status(C.dkim_set_prescreen(lib.lib, nil))
+	}
+	return Status(C.dkim_set_prescreen(lib.lib, (*[0]byte)(C.goPrescreen)))
+}
+
+//export goPrescreen
+func goPrescreen(h *C.DKIM, sigs **C.DKIM_SIGINFO, nsigs C.int) C.DKIM_CBSTAT {
+	d := lookupHandle(h)
+	if d == nil || d.lib == nil {
+		return C.DKIM_CBSTAT_ERROR
+	}
+	d.lib.mtx.Lock()
+	fn := d.lib.prescreen
+	d.lib.mtx.Unlock()
+
+	if fn == nil {
+		return C.DKIM_CBSTAT_CONTINUE
+	}
+	return cbStatus(fn(d.sigList(sigs, nsigs)))
+}
+
+// SetKeyLookup replaces the library's key retrieval with fn, e.g. to
+// read keys from a database instead of DNS. fn returns the key record
+// for the signature's domain and selector, in the same format as the
+// TXT record. Returning StatusNOKEY reports a missing key, StatusKEYFAIL
+// a failed lookup.
+func (lib *Lib) SetKeyLookup(fn func(sig *Signature, domain, selector string) ([]byte, Status)) Status {
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	if (lib.keyLookup == nil) != (fn == nil) {
+		if fn == nil {
+			atomic.AddInt32(&keyLookups, -1)
+		} else {
+			atomic.AddInt32(&keyLookups, 1)
+		}
+	}
+	lib.keyLookup = fn
+	if fn == nil {
+		return Status(C.dkim_set_key_lookup(lib.lib, nil))
+	}
+	return Status(C.dkim_set_key_lookup(lib.lib, (*[0]byte)(C.goKeyLookup)))
+}
+
+// keyLookups counts the libraries with a key lookup function set.
+var keyLookups int32
+
+//export goKeyLookup
+func goKeyLookup(h *C.DKIM, sig *C.DKIM_SIGINFO, buf *C.uchar, buflen C.size_t) C.DKIM_CBSTAT {
+	d := lookupHandle(h)
+	if d == nil || d.lib == nil {
+		// the library of an unknown handle can't be told, so only
+		// fall back to its default lookup if no library has a Go one
+		if atomic.LoadInt32(&keyLookups) == 0 {
+			return C.DKIM_CBSTAT_DEFAULT
+		}
+		return C.DKIM_CBSTAT_ERROR
+	}
+	if sig == nil {
+		return C.DKIM_CBSTAT_ERROR
+	}
+	d.lib.mtx.Lock()
+	fn := d.lib.keyLookup
+	d.lib.mtx.Unlock()
+
+	if fn == nil {
+		return C.DKIM_CBSTAT_DEFAULT
+	}
+	// read without Signature.hold, as Destroy may be waiting for the
+	// signature lock while the caller holds the handle
+	domain := goString(C.dkim_sig_getdomain(sig))
+	selector := goString(C.dkim_sig_getselector(sig))
+	key, stat := fn(&Signature{h: d, sig: sig}, domain, selector)
+	switch stat {
+	case StatusOK:
+	case StatusNOKEY:
+		return C.DKIM_CBSTAT_NOTFOUND
+	case StatusKEYFAIL:
+		return C.DKIM_CBSTAT_ERROR
+	default:
+		return cbStatus(stat)
+	}
+
+	// the record is read as a C string
+	out := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(buflen))
+	if len(key) >= len(out) {
+		return C.DKIM_CBSTAT_ERROR
+	}
+	copy(out, key)
+	out[len(key)] = 0
+	return C.DKIM_CBSTAT_CONTINUE
+}
+
+// Signature contexts are stored in this registry. The library only
+// holds a C allocated copy of the id, which is freed together with the
+// handle owning the signature.
+var sigContexts = struct {
+	sync.Mutex
+	m     map[C.int]interface{}
+	owner map[*C.DKIM][]*C.int
+	next  C.int
+}{m: make(map[C.int]interface{}), owner: make(map[*C.DKIM][]*C.int)}
+
+// SetContext attaches v to the signature. It can be retrieved with
+// Context until the handle is destroyed.
+func (s *Signature) SetContext(v interface{}) {
+	if !s.hold() {
+		return
+	}
+	defer s.release()
+
+	sigContexts.Lock()
+	defer sigContexts.Unlock()
+
+	if h := (*C.int)(C.dkim_sig_getcontext(s.sig)); h != nil {
+		sigContexts.m[*h] = v
+		return
+	}
+	sigContexts.next++
+	h := (*C.int)(C.malloc(C.size_t(unsafe.Sizeof(C.int(0)))))
+	*h = sigContexts.next
+	sigContexts.m[*h] = v
+	sigContexts.owner[s.h.dkim] = append(sigContexts.owner[s.h.dkim], h)
+	C.dkim_sig_setcontext(s.sig, unsafe.Pointer(h))
+}
+
+// Context returns the value attached with SetContext, or nil.
+func (s *Signature) Context() interface{} {
+	if !s.hold() {
+		return nil
+	}
+	defer s.release()
+
+	sigContexts.Lock()
+	defer sigContexts.Unlock()
+
+	h := (*C.int)(C.dkim_sig_getcontext(s.sig))
+	if h == nil {
+		return nil
+	}
+	return sigContexts.m[*h]
+}
+
+// releaseContexts drops the signature contexts of a handle.
+func releaseContexts(d *C.DKIM) {
+	sigContexts.Lock()
+	defer sigContexts.Unlock()
+
+	for _, h := range sigContexts.owner[d] {
+		delete(sigContexts.m, *h)
+		C.free(unsafe.Pointer(h))
+	}
+	delete(sigContexts.owner, d)
+}
+
+// dnsQuery is a query started through a Resolver. The library refers to
+// it by a C allocated copy of its id.
+type dnsQuery struct {
+	r   Resolver
+	id  int
+	buf []byte // reply buffer owned by the library
+}
+
+var dnsQueries = struct {
+	sync.Mutex
+	m    map[C.int]*dnsQuery
+	next C.int
+}{m: make(map[C.int]*dnsQuery)}
+
+// SetResolver routes the library's DNS queries through r. The library
+// must be closed explicitly once a resolver has been set.
+func (lib *Lib) SetResolver(r Resolver) {
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	lib.resolver = r
+	registerLib(lib)
+	C.dkim_dns_set_query_service(lib.lib, unsafe.Pointer(lib.lib))
+	C.dkim_dns_set_query_start(lib.lib, (*[0]byte)(C.goDNSStart))
+	C.dkim_dns_set_query_cancel(lib.lib, (*[0]byte)(C.goDNSCancel))
+	C.dkim_dns_set_query_waitreply(lib.lib, (*[0]byte)(C.goDNSWaitReply))
+}
+
+//export goDNSStart
+func goDNSStart(srv unsafe.Pointer, qtype C.int, query *C.uchar, buf *C.uchar, buflen C.size_t, qh *unsafe.Pointer) C.int {
+	lib := lookupLib((*C.DKIM_LIB)(srv))
+	if lib == nil {
+		return C.DKIM_DNS_ERROR
+	}
+	lib.mtx.Lock()
+	r := lib.resolver
+	lib.mtx.Unlock()
+
+	id, err := r.Start(int(qtype), goString(query))
+	if err != nil {
+		return C.DKIM_DNS_ERROR
+	}
+
+	dnsQueries.Lock()
+	defer dnsQueries.Unlock()
+
+	dnsQueries.next++
+	h := (*C.int)(C.malloc(C.size_t(unsafe.Sizeof(C.int(0)))))
+	*h = dnsQueries.next
+	dnsQueries.m[*h] = &dnsQuery{
+		r:   r,
+		id:  id,
+		buf: unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(buflen)),
+	}
+	*qh = unsafe.Pointer(h)
+	return C.DKIM_DNS_SUCCESS
+}
+
+//export goDNSCancel
+func goDNSCancel(srv unsafe.Pointer, qh unsafe.Pointer) C.int {
+	h := (*C.int)(qh)
+
+	dnsQueries.Lock()
+	q := dnsQueries.m[*h]
+	delete(dnsQueries.m, *h)
+	dnsQueries.Unlock()
+
+	C.free(qh)
+	if q != nil {
+		q.r.Cancel(q.id)
+	}
+	return C.DKIM_DNS_SUCCESS
+}
+
+//export goDNSWaitReply
+func goDNSWaitReply(srv unsafe.Pointer, qh unsafe.Pointer, to *C.struct_timeval, bytes *C.size_t, errp *C.int, dnssec *C.int) C.int {
+	dnsQueries.Lock()
+	q := dnsQueries.m[*(*C.int)(qh)]
+	dnsQueries.Unlock()
+
+	if q == nil {
+		return C.DKIM_DNS_ERROR
+	}
+	timeout := time.Duration(-1)
+	if to != nil {
+		timeout = time.Duration(to.tv_sec)*time.Second + time.Duration(to.tv_usec)*time.Microsecond
+	}
+	var reply []byte
+	var err error
+	status := DNSSECUnknown
+	if r, ok := q.r.(DNSSECResolver); ok {
+		reply, status, err = r.WaitReplyDNSSEC(q.id, timeout)
+	} else {
+		reply, err = q.r.WaitReply(q.id, timeout)
+	}
+	if dnssec != nil {
+		*dnssec = C.int(status)
+	}
+	switch {
+	case errors.Is(err, ErrNoReply):
+		return C.DKIM_DNS_NOREPLY
+	case err != nil:
+		if errp != nil {
+			*errp = -1
+		}
+		return C.DKIM_DNS_ERROR
+	}
+	n := copy(q.buf, reply)
+	if bytes != nil {
+		*bytes = C.size_t(n)
+	}
+	return C.DKIM_DNS_SUCCESS
+}
@@ -0,0 +1,253 @@
+package opendkim
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+)
+
+// requirePass rejects messages without a passing signature.
+func requirePass(sigs []*Signature) Status {
+	for _, sig := range sigs {
+		if sig.Flags()&SigflagPASSED != 0 && sig.BodyHashResult() == BodyHashMATCH {
+			return StatusOK
+		}
+	}
+	return StatusCBREJECT
+}
+
+func TestSetFinal(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	var calls int
+	stat := lib.SetFinal(func(sigs []*Signature) Status {
+		calls++
+		return requirePass(sigs)
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+	verify(lib, msg, t).Destroy()
+	if calls != 1 {
+		t.Fatal(calls)
+	}
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	stat = d.Verify(bytes.NewReader(append(msg, "tampered\r\n"...)))
+	if stat != StatusCBREJECT {
+		t.Fatal(stat)
+	}
+	if calls != 2 {
+		t.Fatal(calls)
+	}
+}
+
+func TestSetKeyLookup(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	keys := map[string]string{
+		selector + "." + domain: testRecord(t),
+	}
+	stat := lib.SetKeyLookup(func(sig *Signature, domain, selector string) ([]byte, Status) {
+		rec, ok := keys[selector+"."+domain]
+		if !ok {
+			return nil, StatusNOKEY
+		}
+		return []byte(rec), StatusOK
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+	verify(lib, msg, t).Destroy()
+
+	delete(keys, selector+"."+domain)
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if stat = d.Verify(bytes.NewReader(msg)); stat == StatusOK {
+		t.Fatal(stat)
+	}
+	if sig := d.GetSignature(); sig == nil || sig.Err() != SigErrorNOKEY {
+		t.Fatal(sig)
+	}
+}
+
+func TestSetKeyLookupProcess(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	calls := 0
+	stat := lib.SetKeyLookup(func(sig *Signature, domain, selector string) ([]byte, Status) {
+		calls++
+		return []byte(testRecord(t)), StatusOK
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	fields, err := readHeader(bufio.NewReader(bytes.NewReader(msg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range fields {
+		if stat = d.Header(h); stat != StatusOK {
+			t.Fatal(stat)
+		}
+	}
+	if stat = d.Eoh(); stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	// processing a signature before Eom looks up its key
+	sigs, stat := d.GetSignatures()
+	if stat != StatusOK || len(sigs) != 1 {
+		t.Fatal(stat, sigs)
+	}
+	if stat = sigs[0].Process(); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if calls != 1 || !sigs[0].KeyLoaded() {
+		t.Fatal(calls, sigs[0].Flags())
+	}
+}
+
+func TestSetPrescreen(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	stat := lib.SetPrescreen(func(sigs []*Signature) Status {
+		for _, sig := range sigs {
+			if sig.Algorithm() == SignRSASHA1 {
+				sig.Ignore()
+			}
+		}
+		return StatusOK
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	signer, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA1, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer signer.Destroy()
+
+	msg, err := signer.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	d.Verify(bytes.NewReader(msg))
+	sigs, _ := d.GetSignatures()
+	if len(sigs) != 1 {
+		t.Fatal(len(sigs))
+	}
+	if f := sigs[0].Flags(); f&SigflagIGNORE == 0 || f&SigflagPROCESSED != 0 {
+		t.Fatal(f)
+	}
+}
+
+func TestSignatureIgnore(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	stat := lib.SetPrescreen(func(sigs []*Signature) Status {
+		if len(sigs) != 2 {
+			return StatusCBERROR
+		}
+		sigs[0].Ignore()
+		return StatusOK
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	msg := sign(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	d := verify(lib, msg, t)
+	defer d.Destroy()
+
+	sigs, stat := d.GetSignatures()
+	if stat != StatusOK || len(sigs) != 2 {
+		t.Fatal(stat, len(sigs))
+	}
+	if f := sigs[0].Flags(); f&SigflagIGNORE == 0 || f&SigflagPROCESSED != 0 {
+		t.Fatal(f)
+	}
+	if f := sigs[1].Flags(); f&SigflagIGNORE != 0 || f&SigflagPROCESSED == 0 || f&SigflagPASSED == 0 {
+		t.Fatal(f)
+	}
+}
+
+func TestSignatureContext(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	stat := lib.SetPrescreen(func(sigs []*Signature) Status {
+		for i, sig := range sigs {
+			sig.SetContext(i)
+		}
+		return StatusOK
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	var got []interface{}
+	stat = lib.SetFinal(func(sigs []*Signature) Status {
+		for _, sig := range sigs {
+			got = append(got, sig.Context())
+		}
+		return StatusOK
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	msg := sign(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	d := verify(lib, msg, t)
+
+	if len(got) != 2 || got[0] != 0 || got[1] != 1 {
+		t.Fatal(got)
+	}
+	sig := d.GetSignature()
+	if sig == nil || sig.Context() == nil {
+		t.Fatal(sig)
+	}
+
+	h := d.dkim
+	d.Destroy()
+	sigContexts.Lock()
+	n := len(sigContexts.owner[h])
+	sigContexts.Unlock()
+	if n != 0 {
+		t.Fatal(n)
+	}
+}
@@ -0,0 +1,280 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"strconv"
+	"strings"
+)
+
+// canonPreview holds the message data passed to a handle, so its
+// canonical form can be reconstructed for debugging.
+type canonPreview struct {
+	hdrs   []string
+	body   bytes.Buffer
+	chunks bytes.Buffer // data passed to Chunk, split on demand
+}
+
+// RecordCanonicalization makes the handle keep a copy of the message data
+// passed to it, which CanonicalizedHeaders and CanonicalizedBody need.
+// It must be called before any data is passed. As the whole message is
+// kept in memory, it is meant for debugging only.
+func (d *Dkim) RecordCanonicalization() Status {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return StatusINVALID
+	}
+	d.preview = &canonPreview{}
+	return StatusOK
+}
+
+// CanonicalizedHeaders returns the header data hashed for the signature
+// after Eom, i.e. the signed header fields in signing order followed by
+// the signature header with an empty b= value, canonicalized as the
+// signature specifies. For a verifier, this is the signature returned by
+// GetSignature.
+//
+// The data is reconstructed in Go from the message passed to the handle,
+// which must have been recorded with RecordCanonicalization.
+func (d *Dkim) CanonicalizedHeaders() ([]byte, Status) {
+	hdrs, _, sigField, stat := d.previewData()
+	if stat != StatusOK {
+		return nil, stat
+	}
+	tags := parseTags(fieldValue(sigField))
+	hc, _ := parseCanon(tags["c"])
+
+	var buf bytes.Buffer
+	used := make([]bool, len(hdrs))
+	for _, name := range strings.Split(tags["h"], ":") {
+		name = strings.TrimSpace(name)
+		// fields of the same name are signed bottom up
+		for i := len(hdrs) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fieldName(hdrs[i]), name) {
+				used[i] = true
+				buf.WriteString(canonHeader(hdrs[i], hc) + "\r\n")
+				break
+			}
+		}
+	}
+	buf.WriteString(canonHeader(stripB(sigField), hc))
+	return buf.Bytes(), StatusOK
+}
+
+// CanonicalizedBody returns the body after Eom, canonicalized as the
+// signature specifies. An l= limit is not applied. Like
+// CanonicalizedHeaders, it requires RecordCanonicalization.
+func (d *Dkim) CanonicalizedBody() ([]byte, Status) {
+	_, body, sigField, stat := d.previewData()
+	if stat != StatusOK {
+		return nil, stat
+	}
+	_, bc := parseCanon(parseTags(fieldValue(sigField))["c"])
+	return canonBody(body, bc), StatusOK
+}
+
+// BodyHashMatches reports whether the body of a verified message still
+// matches the body hash (bh=) of the signature GetSignature returns, i.e.
+// whether the body was altered, independent of the header signature.
+//
+// libopendkim only compares the body hash once it has the signer's key.
+// If it didn't, e.g. because the key lookup failed, the hash is computed
+// from the message recorded with RecordCanonicalization instead; without
+// it, StatusCANTVRFY is returned.
+func (d *Dkim) BodyHashMatches() (bool, Status) {
+	sig := d.GetSignature()
+	if sig == nil {
+		return false, StatusNOSIG
+	}
+	switch sig.BodyHashResult() {
+	case BodyHashMATCH:
+		return true, StatusOK
+	case BodyHashMISMATCH:
+		return false, StatusOK
+	}
+
+	body, stat := d.CanonicalizedBody()
+	if stat != StatusOK {
+		return false, StatusCANTVRFY
+	}
+	if l, ok := sig.TagValue("l"); ok {
+		n, err := strconv.ParseInt(strings.TrimSpace(l), 10, 64)
+		if err != nil || n < 0 {
+			return false, StatusSYNTAX
+		}
+		if n < int64(len(body)) {
+			body = body[:n]
+		}
+	}
+	bh, _ := sig.TagValue("bh")
+	want, err := Base64Decode(bh)
+	if err != nil {
+		return false, StatusSYNTAX
+	}
+	var sum []byte
+	if sig.Algorithm() == SignRSASHA1 {
+		h := sha1.Sum(body)
+		sum = h[:]
+	} else {
+		h := sha256.Sum256(body)
+		sum = h[:]
+	}
+	return bytes.Equal(sum, want), StatusOK
+}
+
+// previewData returns the recorded header fields and body along with the
+// signature header field of the handle. For a verifier, this is the field
+// as received, so it can be canonicalized like the library did.
+func (d *Dkim) previewData() (hdrs []string, body []byte, sigField string, stat Status) {
+	d.mtx.Lock()
+	p := d.preview
+	d.mtx.Unlock()
+
+	if p == nil {
+		return nil, nil, "", StatusINVALID
+	}
+	hdrs, body = p.hdrs, p.body.Bytes()
+	if p.chunks.Len() > 0 {
+		br := bufio.NewReader(bytes.NewReader(p.chunks.Bytes()))
+		var err error
+		if hdrs, err = readHeader(br); err != nil {
+			return nil, nil, "", StatusSYNTAX
+		}
+		if i := headerEnd(p.chunks.Bytes()); i >= 0 {
+			body = p.chunks.Bytes()[i:]
+		} else {
+			body = nil
+		}
+	}
+
+	if d.signing {
+		sigHdr, stat := d.GetSigHdr()
+		return hdrs, body, sigHdrPrefix + sigHdr, stat
+	}
+	sig := d.GetSignature()
+	if sig == nil {
+		return nil, nil, "", StatusNOSIG
+	}
+	b, _ := sig.TagValue("b")
+	b = strings.Join(strings.Fields(b), "")
+	for _, h := range hdrs {
+		if !strings.EqualFold(fieldName(h), "dkim-signature") {
+			continue
+		}
+		if strings.Join(strings.Fields(parseTags(fieldValue(h))["b"]), "") == b {
+			return hdrs, body, h, StatusOK
+		}
+	}
+	return nil, nil, "", StatusNOSIG
+}
+
+func fieldName(field string) string {
+	return strings.TrimSpace(field[:strings.IndexByte(field, ':')])
+}
+
+func fieldValue(field string) string {
+	return field[strings.IndexByte(field, ':')+1:]
+}
+
+// parseTags parses a tag list like the value of a signature header.
+func parseTags(v string) map[string]string {
+	tags := make(map[string]string)
+	for _, t := range strings.Split(v, ";") {
+		i := strings.IndexByte(t, '=')
+		if i < 0 {
+			continue
+		}
+		tags[strings.TrimSpace(t[:i])] = strings.TrimSpace(t[i+1:])
+	}
+	return tags
+}
+
+// parseCanon parses a c= value. The body method defaults to simple, as
+// does a missing value.
+func parseCanon(v string) (hdr, body Canon) {
+	hdr, body = CanonSIMPLE, CanonSIMPLE
+	h, b, _ := strings.Cut(v, "/")
+	if strings.TrimSpace(h) == "relaxed" {
+		hdr = CanonRELAXED
+	}
+	if strings.TrimSpace(b) == "relaxed" {
+		body = CanonRELAXED
+	}
+	return
+}
+
+// stripB empties the b= value of a signature header field or value,
+// keeping everything else as is.
+func stripB(v string) string {
+	var out []string
+	for _, t := range strings.Split(v, ";") {
+		if i := strings.IndexByte(t, '='); i >= 0 && strings.TrimSpace(t[:i]) == "b" {
+			t = t[:i+1]
+		}
+		out = append(out, t)
+	}
+	return strings.Join(out, ";")
+}
+
+// canonHeader canonicalizes a header field (RFC 6376, section 3.4.1 and
+// 3.4.2), without the trailing CRLF.
+func canonHeader(field string, c Canon) string {
+	if c != CanonRELAXED {
+		return field
+	}
+	i := strings.IndexByte(field, ':')
+	name := strings.ToLower(strings.TrimRight(field[:i], " \t"))
+	value := strings.NewReplacer("\r\n", "", "\n", "").Replace(field[i+1:])
+	return name + ":" + strings.TrimSpace(collapseWSP(value))
+}
+
+// canonBody canonicalizes a message body (RFC 6376, section 3.4.3 and
+// 3.4.4).
+func canonBody(body []byte, c Canon) []byte {
+	lines := strings.Split(string(fixCRLF(body)), "\r\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if c == CanonRELAXED {
+		for i, l := range lines {
+			lines[i] = strings.TrimRight(collapseWSP(l), " ")
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if c == CanonRELAXED {
+			return []byte{}
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+// collapseWSP replaces runs of spaces and tabs with a single space.
+func collapseWSP(s string) string {
+	var b strings.Builder
+	wsp := false
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' || s[i] == '\t' {
+			wsp = true
+			continue
+		}
+		if wsp {
+			b.WriteByte(' ')
+			wsp = false
+		}
+		b.WriteByte(s[i])
+	}
+	if wsp {
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
@@ -0,0 +1,198 @@
+package opendkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+// Example from RFC 6376, section 3.4.5.
+const (
+	rfcHeader = "A: X\r\nB : Y\t\r\n\tZ  \r\n"
+	rfcBody   = " C \r\nD \t E\r\n\r\n\r\n"
+)
+
+func TestCanonRFCExample(t *testing.T) {
+	fields, err := readHeader(bufio.NewReader(strings.NewReader(rfcHeader + "\r\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var relaxed, simple []string
+	for _, f := range fields {
+		relaxed = append(relaxed, canonHeader(f, CanonRELAXED))
+		simple = append(simple, canonHeader(f, CanonSIMPLE))
+	}
+	if x := strings.Join(relaxed, "\r\n") + "\r\n"; x != "a:X\r\nb:Y Z\r\n" {
+		t.Fatalf("%q", x)
+	}
+	if x := strings.Join(simple, "\r\n") + "\r\n"; x != rfcHeader {
+		t.Fatalf("%q", x)
+	}
+
+	if x := canonBody([]byte(rfcBody), CanonRELAXED); string(x) != " C\r\nD E\r\n" {
+		t.Fatalf("%q", x)
+	}
+	if x := canonBody([]byte(rfcBody), CanonSIMPLE); string(x) != " C \r\nD \t E\r\n" {
+		t.Fatalf("%q", x)
+	}
+	if x := canonBody(nil, CanonRELAXED); len(x) != 0 {
+		t.Fatalf("%q", x)
+	}
+	if x := canonBody(nil, CanonSIMPLE); string(x) != "\r\n" {
+		t.Fatalf("%q", x)
+	}
+}
+
+func TestCanonicalizedPreview(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	signer, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer signer.Destroy()
+
+	if _, stat := signer.CanonicalizedBody(); stat != StatusINVALID {
+		t.Fatal(stat)
+	}
+	signer.RecordCanonicalization()
+
+	out, err := signer.Sign(strings.NewReader("From: a@b.com\r\n" + rfcHeader + "\r\n" + rfcBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, stat := signer.CanonicalizedBody()
+	if stat != StatusOK || string(body) != " C\r\nD E\r\n" {
+		t.Fatalf("%q %v", body, stat)
+	}
+	hdrs, stat := signer.CanonicalizedHeaders()
+	if stat != StatusOK || !bytes.Contains(hdrs, []byte("from:a@b.com\r\n")) ||
+		!bytes.HasPrefix(hdrs[bytes.LastIndex(hdrs, []byte("\r\n"))+2:], []byte("dkim-signature:")) {
+		t.Fatalf("%q %v", hdrs, stat)
+	}
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	d.RecordCanonicalization()
+	if stat := d.Verify(bytes.NewReader(out)); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	vhdrs, stat := d.CanonicalizedHeaders()
+	if stat != StatusOK || !bytes.Equal(vhdrs, hdrs) {
+		t.Fatalf("%q %v", vhdrs, stat)
+	}
+	if vbody, _ := d.CanonicalizedBody(); !bytes.Equal(vbody, body) {
+		t.Fatalf("%q", vbody)
+	}
+}
+
+func TestCanonicalizedHeadersSimpleVerifier(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	signer, stat := lib.NewSigner(testKey, selector, domain, CanonSIMPLE, CanonSIMPLE, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer signer.Destroy()
+
+	out, err := signer.Sign(strings.NewReader("From: a@b.com\r\n" + rfcHeader + "\r\n" + rfcBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	d.RecordCanonicalization()
+	if stat := d.Verify(bytes.NewReader(out)); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	hdrs, stat := d.CanonicalizedHeaders()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	last := hdrs[bytes.LastIndex(hdrs, []byte("\r\n"))+2:]
+	if !bytes.HasPrefix(last, []byte("DKIM-Signature: v=1;")) || !bytes.HasSuffix(last, []byte("b=")) {
+		t.Fatalf("%q", last)
+	}
+
+	// the data must be what the signature was made over
+	der, err := Base64Decode(parseTags(testRecord(t))["p"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := Base64Decode(sigTag(out, "b", t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(hdrs)
+	if err := rsa.VerifyPKCS1v15(pub.(*rsa.PublicKey), crypto.SHA256, sum[:], sig); err != nil {
+		t.Fatalf("%v: %q", err, hdrs)
+	}
+}
+
+func TestBodyHashMatches(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	// without the key, the hash is computed from the recorded message
+	noKey, stat := lib.NewSigner(testKey, "nokey", domain, CanonRELAXED, CanonSIMPLE, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer noKey.Destroy()
+
+	msgNoKey, err := noKey.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		msg    []byte
+		record bool
+		match  bool
+		stat   Status
+	}{
+		{"intact", msg, false, true, StatusOK},
+		{"altered", append(msg[:len(msg):len(msg)], "altered\r\n"...), false, false, StatusOK},
+		{"no key intact", msgNoKey, true, true, StatusOK},
+		{"no key altered", append(msgNoKey[:len(msgNoKey):len(msgNoKey)], "altered\r\n"...), true, false, StatusOK},
+	} {
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		if tc.record {
+			d.RecordCanonicalization()
+		}
+		d.Verify(bytes.NewReader(tc.msg))
+
+		match, stat := d.BodyHashMatches()
+		if match != tc.match || stat != tc.stat {
+			t.Errorf("%s: %v %v", tc.name, match, stat)
+		}
+		d.Destroy()
+	}
+}
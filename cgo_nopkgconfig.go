@@ -0,0 +1,13 @@
+// +build !windows,nopkgconfig
+
+package opendkim
+
+// Fallback for systems without opendkim.pc, enabled with -tags nopkgconfig.
+// Covers the usual Homebrew, MacPorts and distribution install locations.
+// dkim.h is included without the opendkim/ prefix, like opendkim.pc has it.
+
+/*
+#cgo CFLAGS: -I/opt/homebrew/opt/opendkim/include/opendkim -I/usr/local/opt/opendkim/include/opendkim -I/opt/local/include/opendkim -I/usr/local/include/opendkim -I/usr/include/opendkim
+#cgo LDFLAGS: -L/opt/homebrew/opt/opendkim/lib -L/usr/local/opt/opendkim/lib -L/opt/local/lib -L/usr/local/lib -lopendkim
+*/
+import "C"
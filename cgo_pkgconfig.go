@@ -0,0 +1,6 @@
+// +build !windows,!nopkgconfig
+
+package opendkim
+
+// #cgo pkg-config: opendkim
+import "C"
@@ -0,0 +1,49 @@
+// +build !windows
+
+package opendkim
+
+/*
+#include <stdlib.h>
+#include <sys/types.h>
+#include <dkim.h>
+*/
+import "C"
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var errQPDecode = errors.New("invalid quoted-printable data")
+
+// QPDecode decodes quoted-printable data the way libopendkim does, e.g.
+// for the z= tag of a signature.
+func QPDecode(data []byte) ([]byte, error) {
+	// copied, as appending the NUL could write into data's backing array
+	buf := make([]byte, len(data)+1)
+	copy(buf, data)
+	in := C.CBytes(buf)
+	defer C.free(in)
+
+	out := make([]byte, len(data)+1)
+	n := C.dkim_qp_decode((*C.u_char)(in), bytePtr(out), C.int(len(out)))
+	if n < 0 {
+		return nil, errQPDecode
+	}
+	return out[:n], nil
+}
+
+// Base64Decode decodes base64 data the way libopendkim decodes b= and
+// p= values: characters outside the base64 alphabet, e.g. folding
+// whitespace, are skipped, and padding is optional.
+func Base64Decode(s string) ([]byte, error) {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '+', r == '/':
+			return r
+		}
+		return -1
+	}, s)
+	return base64.RawStdEncoding.DecodeString(s)
+}
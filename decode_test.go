@@ -0,0 +1,54 @@
+package opendkim
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestQPDecode(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                  "",
+		"plain text":        "plain text",
+		"> B=C3=BCro":       "> B\xc3\xbcro",
+		"From:=20a=40b.com": "From: a@b.com",
+	} {
+		out, err := QPDecode([]byte(in))
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		if !bytes.Equal(out, []byte(want)) {
+			t.Errorf("%q: %q != %q", in, out, want)
+		}
+	}
+}
+
+func TestQPDecodeSubSlice(t *testing.T) {
+	buf := []byte("a=3Db|rest")
+	out, err := QPDecode(buf[:5])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "a=b" || string(buf) != "a=3Db|rest" {
+		t.Fatalf("%q %q", out, buf)
+	}
+}
+
+func TestBase64Decode(t *testing.T) {
+	for in, want := range map[string]string{
+		"":                        "",
+		"aGVsbG8=":                "hello",
+		"aGVsbG8":                 "hello",
+		"aGVs\r\n\tbG8gd29y bGQ=": "hello world",
+	} {
+		out, err := Base64Decode(in)
+		if err != nil {
+			t.Fatal(in, err)
+		}
+		if !bytes.Equal(out, []byte(want)) {
+			t.Errorf("%q: %q != %q", in, out, want)
+		}
+	}
+	if _, err := Base64Decode("a"); err == nil {
+		t.Fatal()
+	}
+}
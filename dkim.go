@@ -3,8 +3,7 @@
 package opendkim
 
 /*
-#cgo LDFLAGS: -L/usr/local/opt/opendkim/lib -lopendkim
-#cgo CFLAGS: -g -O2 -Wno-error -I/opt/local/include/opendkim/ -I/usr/include/opendkim/
+#cgo CFLAGS: -g -O2 -Wno-error
 
 #include <stdio.h>
 #include <stdlib.h>
@@ -13,26 +12,59 @@ package opendkim
 #include <sys/types.h>
 #include <sys/stat.h>
 #include <fcntl.h>
-#include <opendkim/dkim.h>
+#include <dkim.h>
+
+// Features only newer versions of libopendkim know about. Those missing
+// from dkim.h get a value HasFeature reports as unsupported.
+#define GO_FEATURE_UNDEFINED 0xffff
+#ifdef DKIM_FEATURE_CONDITIONAL
+# define GO_FEATURE_CONDITIONAL DKIM_FEATURE_CONDITIONAL
+#else
+# define GO_FEATURE_CONDITIONAL GO_FEATURE_UNDEFINED
+#endif
+#ifdef DKIM_FEATURE_ED25519
+# define GO_FEATURE_ED25519 DKIM_FEATURE_ED25519
+#else
+# define GO_FEATURE_ED25519 GO_FEATURE_UNDEFINED
+#endif
 */
 import "C"
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
-	"net/mail"
+	"os"
 	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
+var errMalformedHeader = errors.New("malformed message header")
+
 type (
-	Canon   int
-	Sign    int
-	Op      int
-	Option  int
-	Sigflag uint
+	Canon      int
+	Sign       int
+	Op         int
+	Option     int
+	Sigflag    uint
+	BodyHash   int
+	SigError   int
+	DNSSEC     int
+	DNSSECMode int
+	ATPSResult int
+	Feature    uint
+	Libflag    uint
 )
 
 const (
@@ -46,27 +78,29 @@ const (
 	SignDEFAULT   Sign = -1 // use internal default
 	SignRSASHA1   Sign = 0  // an RSA-signed SHA1 digest
 	SignRSASHA256 Sign = 1  // an RSA-signed SHA256 digest
+
+	SignED25519SHA256 Sign = 2 // an Ed25519-signed SHA256 digest (RFC 8463)
 )
 
 const (
-	StatusOK            = 0  // function completed successfully
-	StatusBADSIG        = 1  // signature available but failed
-	StatusNOSIG         = 2  // no signature available
-	StatusNOKEY         = 3  // public key not found
-	StatusCANTVRFY      = 4  // can't get domain key to verify
-	StatusSYNTAX        = 5  // message is not valid syntax
-	StatusNORESOURCE    = 6  // resource unavailable
-	StatusINTERNAL      = 7  // internal error
-	StatusREVOKED       = 8  // key found, but revoked
-	StatusINVALID       = 9  // invalid function parameter
-	StatusNOTIMPLEMENT  = 10 // function not implemented
-	StatusKEYFAIL       = 11 // key retrieval failed
-	StatusCBREJECT      = 12 // callback requested reject
-	StatusCBINVALID     = 13 // callback gave invalid result
-	StatusCBTRYAGAIN    = 14 // callback says try again later
-	StatusCBERROR       = 15 // callback error
-	StatusMULTIDNSREPLY = 16 // multiple DNS replies
-	StatusSIGGEN        = 17 // signature generation failed
+	StatusOK            Status = 0  // function completed successfully
+	StatusBADSIG        Status = 1  // signature available but failed
+	StatusNOSIG         Status = 2  // no signature available
+	StatusNOKEY         Status = 3  // public key not found
+	StatusCANTVRFY      Status = 4  // can't get domain key to verify
+	StatusSYNTAX        Status = 5  // message is not valid syntax
+	StatusNORESOURCE    Status = 6  // resource unavailable
+	StatusINTERNAL      Status = 7  // internal error
+	StatusREVOKED       Status = 8  // key found, but revoked
+	StatusINVALID       Status = 9  // invalid function parameter
+	StatusNOTIMPLEMENT  Status = 10 // function not implemented
+	StatusKEYFAIL       Status = 11 // key retrieval failed
+	StatusCBREJECT      Status = 12 // callback requested reject
+	StatusCBINVALID     Status = 13 // callback gave invalid result
+	StatusCBTRYAGAIN    Status = 14 // callback says try again later
+	StatusCBERROR       Status = 15 // callback error
+	StatusMULTIDNSREPLY Status = 16 // multiple DNS replies
+	StatusSIGGEN        Status = 17 // signature generation failed
 )
 
 const (
@@ -89,32 +123,124 @@ const (
 )
 
 const (
-	LibflagsNONE          = 0x0000
-	LibflagsTMPFILES      = 0x0001
-	LibflagsKEEPFILES     = 0x0002
-	LibflagsSIGNLEN       = 0x0004
-	LibflagsCACHE         = 0x0008
-	LibflagsZTAGS         = 0x0010
-	LibflagsDELAYSIGPROC  = 0x0020
-	LibflagsEOHCHECK      = 0x0040
-	LibflagsACCEPTV05     = 0x0080
-	LibflagsFIXCRLF       = 0x0100
-	LibflagsACCEPTDK      = 0x0200
-	LibflagsBADSIGHANDLES = 0x0400
-	LibflagsVERIFYONE     = 0x0800
-	LibflagsSTRICTHDRS    = 0x1000
-	LibflagsREPORTBADADSP = 0x2000
-	LibflagsDROPSIGNER    = 0x4000
-	LibflagsSTRICTRESIGN  = 0x8000
+	LibflagsNONE          Libflag = 0x0000
+	LibflagsTMPFILES      Libflag = 0x0001
+	LibflagsKEEPFILES     Libflag = 0x0002
+	LibflagsSIGNLEN       Libflag = 0x0004
+	LibflagsCACHE         Libflag = 0x0008
+	LibflagsZTAGS         Libflag = 0x0010
+	LibflagsDELAYSIGPROC  Libflag = 0x0020
+	LibflagsEOHCHECK      Libflag = 0x0040
+	LibflagsACCEPTV05     Libflag = 0x0080
+	LibflagsFIXCRLF       Libflag = 0x0100
+	LibflagsACCEPTDK      Libflag = 0x0200
+	LibflagsBADSIGHANDLES Libflag = 0x0400
+	LibflagsVERIFYONE     Libflag = 0x0800
+	LibflagsSTRICTHDRS    Libflag = 0x1000
+	LibflagsREPORTBADADSP Libflag = 0x2000
+	LibflagsDROPSIGNER    Libflag = 0x4000
+	LibflagsSTRICTRESIGN  Libflag = 0x8000
+)
+
+const (
+	SigflagIGNORE      Sigflag = 0x01
+	SigflagPROCESSED   Sigflag = 0x02
+	SigflagPASSED      Sigflag = 0x04
+	SigflagTESTKEY     Sigflag = 0x08
+	SigflagNOSUBDOMAIN Sigflag = 0x10
+	SigflagKEYLOADED   Sigflag = 0x20
+)
+
+const (
+	BodyHashUNTESTED BodyHash = (-1) // body hash not computed yet
+	BodyHashMATCH    BodyHash = 0    // body hash matched
+	BodyHashMISMATCH BodyHash = 1    // body hash did not match
+)
+
+const (
+	SigErrorUNKNOWN         SigError = (-1) // unknown error
+	SigErrorOK              SigError = 0    // no error
+	SigErrorVERSION         SigError = 1    // unsupported version
+	SigErrorDOMAIN          SigError = 2    // invalid domain (d=/i=)
+	SigErrorEXPIRED         SigError = 3    // signature expired
+	SigErrorFUTURE          SigError = 4    // signature in the future
+	SigErrorTIMESTAMPS      SigError = 5    // x= < t=
+	SigErrorUNUSED          SigError = 6    // OBSOLETE
+	SigErrorINVALIDHC       SigError = 7    // c= invalid (header)
+	SigErrorINVALIDBC       SigError = 8    // c= invalid (body)
+	SigErrorMISSINGA        SigError = 9    // a= missing
+	SigErrorINVALIDA        SigError = 10   // a= invalid
+	SigErrorMISSINGH        SigError = 11   // h= missing
+	SigErrorINVALIDL        SigError = 12   // l= invalid
+	SigErrorINVALIDQ        SigError = 13   // q= invalid
+	SigErrorINVALIDQO       SigError = 14   // q= option invalid
+	SigErrorMISSINGD        SigError = 15   // d= missing
+	SigErrorEMPTYD          SigError = 16   // d= empty
+	SigErrorMISSINGS        SigError = 17   // s= missing
+	SigErrorEMPTYS          SigError = 18   // s= empty
+	SigErrorMISSINGB        SigError = 19   // b= missing
+	SigErrorEMPTYB          SigError = 20   // b= empty
+	SigErrorCORRUPTB        SigError = 21   // b= corrupt
+	SigErrorNOKEY           SigError = 22   // no key found in DNS
+	SigErrorDNSSYNTAX       SigError = 23   // DNS reply corrupt
+	SigErrorKEYFAIL         SigError = 24   // DNS query failed
+	SigErrorMISSINGBH       SigError = 25   // bh= missing
+	SigErrorEMPTYBH         SigError = 26   // bh= empty
+	SigErrorCORRUPTBH       SigError = 27   // bh= corrupt
+	SigErrorBADSIG          SigError = 28   // signature mismatch
+	SigErrorSUBDOMAIN       SigError = 29   // unauthorized subdomain
+	SigErrorMULTIREPLY      SigError = 30   // multiple records returned
+	SigErrorEMPTYH          SigError = 31   // h= empty
+	SigErrorINVALIDH        SigError = 32   // h= missing req'd entries
+	SigErrorTOOLARGEL       SigError = 33   // l= value exceeds body size
+	SigErrorMBSFAILED       SigError = 34   // "must be signed" failure
+	SigErrorKEYVERSION      SigError = 35   // unknown key version
+	SigErrorKEYUNKNOWNHASH  SigError = 36   // unknown key hash
+	SigErrorKEYHASHMISMATCH SigError = 37   // sig-key hash mismatch
+	SigErrorNOTEMAILKEY     SigError = 38   // not an e-mail key
+	SigErrorUNUSED2         SigError = 39   // OBSOLETE
+	SigErrorKEYTYPEMISSING  SigError = 40   // key type missing
+	SigErrorKEYTYPEUNKNOWN  SigError = 41   // key type unknown
+	SigErrorKEYREVOKED      SigError = 42   // key revoked
+	SigErrorKEYDECODE       SigError = 43   // key couldn't be decoded
+	SigErrorMISSINGV        SigError = 44   // v= tag missing
+	SigErrorEMPTYV          SigError = 45   // v= tag empty
+	SigErrorKEYTOOSMALL     SigError = 46   // too few key bits
+)
+
+const (
+	DNSSECUnknown  DNSSEC = (-1) // not evaluated
+	DNSSECBogus    DNSSEC = 0    // validation failed
+	DNSSECInsecure DNSSEC = 1    // not signed
+	DNSSECSecure   DNSSEC = 2    // validated
+)
+
+// DNSSEC requirements on keys, see SetDNSSECMode.
+const (
+	DNSSECModeNone     DNSSECMode = iota // no requirement
+	DNSSECModeNotBogus                   // keys failing validation are rejected
+	DNSSECModeInsecure                   // keys must be evaluated and insecure or secure
+	DNSSECModeSecure                     // keys must be validated
 )
 
+// accepts reports whether a key with DNSSEC status s meets the
+// requirement.
+func (m DNSSECMode) accepts(s DNSSEC) bool {
+	switch m {
+	case DNSSECModeNone:
+		return true
+	case DNSSECModeNotBogus:
+		return s != DNSSECBogus
+	case DNSSECModeInsecure:
+		return s == DNSSECInsecure || s == DNSSECSecure
+	}
+	return s == DNSSECSecure
+}
+
 const (
-	SigflagIGNORE      = 0x01
-	SigflagPROCESSED   = 0x02
-	SigflagPASSED      = 0x04
-	SigflagTESTKEY     = 0x08
-	SigflagNOSUBDOMAIN = 0x10
-	SigflagKEYLOADED   = 0x20
+	ATPSUnknown  ATPSResult = (-1) // not checked or query failed
+	ATPSNotFound ATPSResult = 0    // no authorization found
+	ATPSFound    ATPSResult = 1    // signer is authorized
 )
 
 const (
@@ -123,20 +249,51 @@ const (
 	QueryFILE    = 1    // text file method (for testing)
 )
 
+const (
+	FeatureDIFFHEADERS Feature = 0 // DiffHeaders support
+	FeatureUNUSED      Feature = 1 // unused
+	FeaturePARSETIME   Feature = 2 // parse-time measurement
+	FeatureQUERYCACHE  Feature = 3 // key query cache (LibflagsCACHE)
+	FeatureSHA256      Feature = 4 // RSA-SHA256 signing
+	FeatureOVERSIGN    Feature = 5 // header oversigning
+	FeatureDNSSEC      Feature = 6 // DNSSEC status of key lookups
+	FeatureRESIGN      Feature = 7 // Resign support
+	FeatureATPS        Feature = 8 // ATPS checks
+	FeatureXTAGS       Feature = 9 // extension tags
+
+	FeatureCONDITIONAL Feature = C.GO_FEATURE_CONDITIONAL // conditional signatures
+	FeatureED25519     Feature = C.GO_FEATURE_ED25519     // Ed25519 signing
+
+	featureUndefined Feature = C.GO_FEATURE_UNDEFINED // not in dkim.h
+)
+
 const (
 	GetOpt Op = 0
 	SetOpt Op = 1
 )
 
 // Lib is a dkim library handle
+//
+// libopendkim allows handles created from the same library handle to be
+// used concurrently, so signers and verifiers only lock the Lib briefly to
+// read its flags and callbacks, not while the library processes a
+// message. Options and callbacks should be set up before handles are
+// created. See LibPool for spreading load across several library handles.
 type Lib struct {
 	lib *C.DKIM_LIB
 	mtx sync.Mutex
+
+	resolver  Resolver
+	final     func([]*Signature) Status
+	prescreen func([]*Signature) Status
+	keyLookup func(sig *Signature, domain, selector string) ([]byte, Status)
+	dnssec    DNSSECMode          // required DNSSEC status of keys
+	lists     map[Option][]string // header lists, see setStrings
 }
 
 // Init inits a new dkim library handle
 func Init() *Lib {
-	lib := new(Lib)
+	lib := &Lib{}
 	lib.lib = C.dkim_init(nil, nil)
 	if lib.lib == nil {
 		panic("could not init libopendkim")
@@ -149,10 +306,90 @@ func Init() *Lib {
 
 // Options sets or gets library options
 func (lib *Lib) Options(op Op, opt Option, ptr unsafe.Pointer, size uintptr) {
+	lib.options(op, opt, ptr, size)
+}
+
+func (lib *Lib) options(op Op, opt Option, ptr unsafe.Pointer, size uintptr) Status {
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	return Status(C.dkim_options(lib.lib, C.int(op), C.dkim_opts_t(opt), ptr, C.size_t(size)))
+}
+
+// HasFeature reports whether the linked libopendkim was built with
+// support for the given feature.
+func (lib *Lib) HasFeature(f Feature) bool {
+	if f == featureUndefined {
+		return false
+	}
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	return bool(C.dkim_libfeature(lib.lib, C.u_int(f)))
+}
+
+// ValidateKeyRecord checks whether libopendkim accepts the syntax of a
+// key record, e.g. before it is published for domain. The library checks
+// the record on its own, so domain is currently unused.
+func (lib *Lib) ValidateKeyRecord(domain, record string) Status {
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		return stat
+	}
+	defer d.Destroy()
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	data := []byte(record)
+	return Status(C.dkim_key_syntax(d.dkim, bytePtr(data), C.size_t(len(data))))
+}
+
+// SSLVersion returns the OpenSSL version number (OPENSSL_VERSION_NUMBER)
+// libopendkim was built against.
+func (lib *Lib) SSLVersion() uint {
+	return uint(C.dkim_ssl_version())
+}
+
+// Version describes the linked libopendkim and the OpenSSL version it was
+// built against, e.g. for bug reports.
+func Version() string {
+	v := uint32(C.dkim_libversion())
+	return fmt.Sprintf("libopendkim %d.%d.%d.%d, OpenSSL %#x", v>>24, v>>16&0xff, v>>8&0xff, v&0xff, uint(C.dkim_ssl_version()))
+}
+
+// CacheStats holds the statistics of the key query cache.
+type CacheStats struct {
+	Queries uint // lookups against the cache
+	Hits    uint // lookups answered from the cache
+	Expired uint // entries that had expired when looked up
+	Keys    uint // keys currently cached
+}
+
+// FlushCache removes expired entries from the key query cache, which is
+// used if LibflagsCACHE is set, and returns how many were removed.
+func (lib *Lib) FlushCache() int {
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	return int(C.dkim_flush_cache(lib.lib))
+}
+
+// CacheStats returns the statistics of the key query cache. If the
+// library was built without cache support, StatusNOTIMPLEMENT is
+// returned.
+func (lib *Lib) CacheStats() (CacheStats, Status) {
 	lib.mtx.Lock()
 	defer lib.mtx.Unlock()
 
-	C.dkim_options(lib.lib, C.int(op), C.dkim_opts_t(opt), ptr, C.size_t(size))
+	var queries, hits, expired, keys C.u_int
+	stat := Status(C.dkim_getcachestats(lib.lib, &queries, &hits, &expired, &keys, C._Bool(false)))
+	return CacheStats{
+		Queries: uint(queries),
+		Hits:    uint(hits),
+		Expired: uint(expired),
+		Keys:    uint(keys),
+	}, stat
 }
 
 // Close closes the dkim lib
@@ -161,30 +398,102 @@ func (lib *Lib) Close() {
 	defer lib.mtx.Unlock()
 
 	if lib.lib != nil {
+		unregisterLib(lib.lib)
 		C.dkim_close(lib.lib)
 		lib.lib = nil
 	}
+	if lib.keyLookup != nil {
+		lib.keyLookup = nil
+		atomic.AddInt32(&keyLookups, -1)
+	}
 }
 
 // Dkim handle
+//
+// Methods are safe to call concurrently with Destroy and the finalizer;
+// once the handle has been destroyed they return StatusINVALID.
 type Dkim struct {
-	dkim *C.DKIM
-	mtx  sync.Mutex
+	dkim  *C.DKIM
+	lib   *Lib
+	mtx   sync.Mutex
+	from  string // value of the first From header passed to Header
+	nfrom int    // number of From headers passed to Header
+	orig  *Dkim  // verifier a resigning handle is bound to
+
+	signing bool
+	preview *canonPreview // data recorded for the canonicalization preview
+
+	// sigMtx is read locked by Signature methods and write locked by
+	// Destroy, so signatures can't be used while the handle is freed. It
+	// is separate from mtx, as callbacks access signatures while Eom
+	// holds mtx.
+	sigMtx sync.RWMutex
+
+	// rejected holds the passing signatures whose keys don't meet the
+	// library's DNSSEC requirement. It is guarded by sigMtx.
+	rejected map[*C.DKIM_SIGINFO]bool
 }
 
 // NewSigner creates a new DKIM handle for message signing.
 // If -1 is specified for bytesToSign, the whole message body will be signed.
+// Otherwise only the first bytesToSign bytes of the canonicalized body are
+// signed, and the signature carries the matching l= tag.
 func (lib *Lib) NewSigner(secret, selector, domain string, hdrCanon, bodyCanon Canon, algo Sign, bytesToSign int64) (*Dkim, Status) {
+	csecret := C.CString(secret)
+	defer C.free(unsafe.Pointer(csecret))
+
+	return lib.newSigner((*C.uchar)(unsafe.Pointer(csecret)), selector, domain, hdrCanon, bodyCanon, algo, bytesToSign)
+}
+
+// NewSignerBytes is like NewSigner, but takes the private key as bytes.
+// Besides PEM, DER encoded keys (PKCS#1 RSA or PKCS#8 RSA and Ed25519)
+// are accepted; they are converted to PEM
+// since the library reads the key as a NUL-terminated string. The copy
+// handed to the library is wiped afterwards, so callers are free to zero
+// secret once this returns.
+func (lib *Lib) NewSignerBytes(secret []byte, selector, domain string, hdrCanon, bodyCanon Canon, algo Sign, bytesToSign int64) (*Dkim, Status) {
+	key := secret
+	if !bytes.HasPrefix(bytes.TrimSpace(secret), []byte("-----")) {
+		key = derToPEM(secret)
+		if key == nil {
+			return nil, Status(StatusINVALID)
+		}
+		defer zero(key)
+	}
+	if bytes.IndexByte(key, 0) >= 0 {
+		// would be truncated by the library
+		return nil, Status(StatusINVALID)
+	}
+
+	n := C.size_t(len(key))
+	csecret := (*C.uchar)(C.malloc(n + 1))
+	defer C.free(unsafe.Pointer(csecret))
+	defer C.memset(unsafe.Pointer(csecret), 0, n+1)
+
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(csecret)), len(key)+1)
+	copy(buf, key)
+	buf[len(key)] = 0
+
+	return lib.newSigner(csecret, selector, domain, hdrCanon, bodyCanon, algo, bytesToSign)
+}
+
+func (lib *Lib) newSigner(csecret *C.uchar, selector, domain string, hdrCanon, bodyCanon Canon, algo Sign, bytesToSign int64) (*Dkim, Status) {
 	var stat C.DKIM_STAT
 
-	signer := new(Dkim)
+	// libopendkim copies these, so they can be freed once dkim_sign returns
+	cselector := C.CString(selector)
+	defer C.free(unsafe.Pointer(cselector))
+	cdomain := C.CString(domain)
+	defer C.free(unsafe.Pointer(cdomain))
+
+	signer := &Dkim{lib: lib, signing: true}
 	signer.dkim = C.dkim_sign(
 		lib.lib,
 		nil,
 		nil,
-		(*C.uchar)(unsafe.Pointer(C.CString(secret))),
-		(*C.uchar)(unsafe.Pointer(C.CString(selector))),
-		(*C.uchar)(unsafe.Pointer(C.CString(domain))),
+		csecret,
+		(*C.uchar)(unsafe.Pointer(cselector)),
+		(*C.uchar)(unsafe.Pointer(cdomain)),
 		C.dkim_canon_t(hdrCanon),
 		C.dkim_canon_t(bodyCanon),
 		C.dkim_alg_t(algo),
@@ -196,17 +505,67 @@ func (lib *Lib) NewSigner(secret, selector, domain string, hdrCanon, bodyCanon C
 	if s != StatusOK {
 		return nil, s
 	}
+	if bytesToSign >= 0 {
+		// l= is set on the handle, as LibflagsSIGNLEN would add it to
+		// every later signature of lib too
+		if s = Status(C.dkim_setpartial(signer.dkim, C._Bool(true))); s != StatusOK {
+			C.dkim_free(signer.dkim)
+			return nil, s
+		}
+	}
 	runtime.SetFinalizer(signer, func(s *Dkim) {
 		s.Destroy()
 	})
 	return signer, s
 }
 
+// derToPEM wraps a DER encoded PKCS#1 or PKCS#8 private key in PEM.
+// It returns nil if der is neither.
+func derToPEM(der []byte) []byte {
+	typ := "RSA PRIVATE KEY"
+	if _, err := x509.ParsePKCS1PrivateKey(der); err != nil {
+		if _, err := x509.ParsePKCS8PrivateKey(der); err != nil {
+			return nil
+		}
+		typ = "PRIVATE KEY"
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+// NewSignerFromKeyFile is like NewSigner, but reads the PEM encoded
+// private key from a file. The key is loaded right away, so a bad key
+// is reported here rather than when the signature is generated.
+func (lib *Lib) NewSignerFromKeyFile(path, selector, domain string, hdrCanon, bodyCanon Canon, algo Sign, bytesToSign int64) (*Dkim, error) {
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(key); block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", path)
+	}
+	signer, stat := lib.NewSigner(string(key), selector, domain, hdrCanon, bodyCanon, algo, bytesToSign)
+	if stat != StatusOK {
+		return nil, stat
+	}
+	if stat = Status(C.dkim_privkey_load(signer.dkim)); stat != StatusOK {
+		err := signer.statusError(stat)
+		signer.Destroy()
+		return nil, err
+	}
+	return signer, nil
+}
+
 // NewVerifier creates a new DKIM verifier
 func (lib *Lib) NewVerifier() (*Dkim, Status) {
 	var stat C.DKIM_STAT
 
-	vrfy := new(Dkim)
+	vrfy := &Dkim{lib: lib}
 	vrfy.dkim = C.dkim_verify(lib.lib, nil, nil, &stat)
 
 	s := Status(stat)
@@ -219,20 +578,64 @@ func (lib *Lib) NewVerifier() (*Dkim, Status) {
 	return vrfy, s
 }
 
+// Resign creates a signing handle bound to the verifying handle orig, so
+// a message can be verified and signed again in a single pass, e.g. by a
+// mailing list or forwarder. Existing signatures are left in place.
+//
+// Resign must be called before any data is passed to orig. The body
+// passed to orig is shared with the new handle. If hdrbind is true, so
+// are the headers; otherwise they have to be passed to both handles.
+// After orig's Eom, call Eom on the new handle and fetch its signature
+// with GetSigHdr.
+func (lib *Lib) Resign(orig *Dkim, secret, selector, domain string, hdrCanon, bodyCanon Canon, algo Sign, hdrbind bool) (*Dkim, Status) {
+	signer, stat := lib.NewSigner(secret, selector, domain, hdrCanon, bodyCanon, algo, -1)
+	if stat != StatusOK {
+		return nil, stat
+	}
+
+	orig.mtx.Lock()
+	defer orig.mtx.Unlock()
+
+	if orig.dkim == nil {
+		signer.Destroy()
+		return nil, Status(StatusINVALID)
+	}
+	stat = Status(C.dkim_resign(signer.dkim, orig.dkim, C._Bool(hdrbind)))
+	if stat != StatusOK {
+		signer.Destroy()
+		return nil, stat
+	}
+	// keep orig alive for as long as the signer refers to it
+	signer.orig = orig
+	return signer, stat
+}
+
 // Sign is a helper method for signing a block of message data.
 // The message data includes header and body.
 func (d *Dkim) Sign(r io.Reader) ([]byte, error) {
-	hdr, body, stat := d.process(r)
+	return d.signed(d.process(r))
+}
+
+// SignContext is like Sign, but bounds the end of message processing by
+// ctx like EomContext. If ctx is done first, the error is
+// StatusCBTRYAGAIN.
+func (d *Dkim) SignContext(ctx context.Context, r io.Reader) ([]byte, error) {
+	return d.signed(d.processContext(ctx, r))
+}
+
+// signed returns the message processed by a signing handle with the
+// signature header added.
+func (d *Dkim) signed(hdr, body *bytes.Buffer, stat Status) ([]byte, error) {
 	if stat != StatusOK {
-		return nil, stat
+		return nil, d.statusError(stat)
 	}
 
 	sigHdr, stat := d.GetSigHdr()
 	if stat != StatusOK {
-		return nil, stat
+		return nil, d.statusError(stat)
 	}
 
-	hdr.WriteString(`DKIM-Signature: ` + sigHdr + "\r\n\r\n")
+	hdr.WriteString(sigField(sigHdr) + "\r\n")
 
 	var out bytes.Buffer
 	io.Copy(&out, hdr)
@@ -247,141 +650,1234 @@ func (d *Dkim) Verify(r io.Reader) Status {
 	return stat
 }
 
-func (d *Dkim) process(r io.Reader) (hdr, body *bytes.Buffer, stat Status) {
-	msg, err := mail.ReadMessage(r)
-	if err != nil {
-		return nil, nil, Status(StatusINTERNAL)
+// VerifyContext is like Verify, but bounds key lookups by ctx like
+// EomContext. If ctx is done before the result is known,
+// StatusCBTRYAGAIN is returned, so the message can be deferred.
+func (d *Dkim) VerifyContext(ctx context.Context, r io.Reader) Status {
+	_, _, stat := d.processContext(ctx, r)
+	return stat
+}
+
+// bodyChunkSize is the size of the chunks VerifyStream passes the body in.
+const bodyChunkSize = 64 << 10
+
+// VerifyStream verifies a message in one step like Verify, but passes the
+// body to the library in fixed-size chunks instead of buffering it first,
+// so memory use stays constant regardless of the message size.
+func (d *Dkim) VerifyStream(r io.Reader) Status {
+	br := bufio.NewReader(r)
+	_, stat := d.processHeader(br)
+	if stat != StatusOK {
+		return stat
 	}
-	hdr = bytes.NewBuffer(nil)
-	for k, vv := range msg.Header {
-		for _, v := range vv {
-			h := k + `: ` + v
-			stat = d.Header(h)
+
+	buf := make([]byte, bodyChunkSize)
+	for {
+		n, err := io.ReadFull(br, buf)
+		if n > 0 {
+			stat = d.Body(buf[:n])
 			if stat != StatusOK {
-				return
+				return stat
 			}
-			hdr.WriteString(h + "\r\n")
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return Status(StatusINTERNAL)
 		}
 	}
+	return d.Eom(nil)
+}
 
-	stat = d.Eoh()
-	if stat != StatusOK {
-		return
+func (d *Dkim) process(r io.Reader) (hdr, body *bytes.Buffer, stat Status) {
+	return d.processEom(r, func() Status { return d.Eom(nil) })
+}
+
+func (d *Dkim) processContext(ctx context.Context, r io.Reader) (hdr, body *bytes.Buffer, stat Status) {
+	if ctx.Err() != nil {
+		return nil, nil, StatusCBTRYAGAIN
+	}
+	return d.processEom(r, func() Status { return d.EomContext(ctx, nil) })
+}
+
+// processEom passes the message read from r to the library, using eom to
+// signal the end of message. The header and body are returned as passed.
+func (d *Dkim) processEom(r io.Reader, eom func() Status) (hdr, body *bytes.Buffer, stat Status) {
+	fields, data, err := d.readMessage(r)
+	if err != nil {
+		return nil, nil, Status(StatusINTERNAL)
 	}
+	return d.feed(fields, data, eom)
+}
 
-	body = bytes.NewBuffer(nil)
-	io.Copy(body, msg.Body)
+// readMessage reads a message from r. The header fields are returned as
+// by readHeader, the body with bare LFs fixed if LibflagsFIXCRLF is set.
+func (d *Dkim) readMessage(r io.Reader) (fields []string, body []byte, err error) {
+	br := bufio.NewReader(r)
+	if fields, err = readHeader(br); err != nil {
+		return nil, nil, err
+	}
+	if body, err = io.ReadAll(br); err != nil {
+		return nil, nil, err
+	}
+	if d.lib != nil && d.lib.Flags()&LibflagsFIXCRLF != 0 {
+		body = fixCRLF(body)
+	}
+	return fields, body, nil
+}
 
-	stat = d.Body(body.Bytes())
+// feed passes a message returned by readMessage to the library, using eom
+// to signal the end of message. The header and body are returned as
+// passed.
+func (d *Dkim) feed(fields []string, data []byte, eom func() Status) (hdr, body *bytes.Buffer, stat Status) {
+	hdr, stat = d.feedHeader(fields)
+	if stat != StatusOK {
+		return
+	}
+	body = bytes.NewBuffer(data)
+	stat = d.Body(data)
 	if stat != StatusOK {
 		return
 	}
-	stat = d.Eom(nil)
+	stat = eom()
+	return
+}
+
+// processHeader reads the message header from br, passes it to the library
+// and signals the end of header. The header is returned as written.
+func (d *Dkim) processHeader(br *bufio.Reader) (hdr *bytes.Buffer, stat Status) {
+	fields, err := readHeader(br)
+	if err != nil {
+		return nil, Status(StatusINTERNAL)
+	}
+	return d.feedHeader(fields)
+}
+
+// feedHeader passes header fields to the library and signals the end of
+// header. The header is returned as written.
+func (d *Dkim) feedHeader(fields []string) (hdr *bytes.Buffer, stat Status) {
+	hdr = bytes.NewBuffer(nil)
+	for _, h := range fields {
+		stat = d.Header(h)
+		if stat != StatusOK {
+			return
+		}
+		hdr.WriteString(h + "\r\n")
+	}
+	stat = d.Eoh()
 	return
 }
 
+// fixCRLF converts bare LF line endings to CRLF.
+func fixCRLF(data []byte) []byte {
+	n := bytes.Count(data, []byte("\n")) - bytes.Count(data, []byte("\r\n"))
+	if n == 0 {
+		return data
+	}
+	out := make([]byte, 0, len(data)+n)
+	for i, c := range data {
+		if c == '\n' && (i == 0 || data[i-1] != '\r') {
+			out = append(out, '\r')
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// readHeader reads the header block of a message and returns its fields
+// in the order they appeared on the wire, which is the order the library
+// has to see them in. Fields are returned as is, so simple header
+// canonicalization sees the original bytes; folded fields keep their
+// continuation lines, joined by CRLF.
+func readHeader(br *bufio.Reader) ([]string, error) {
+	var fields []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return nil, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			return fields, nil
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(fields) == 0 {
+				return nil, errMalformedHeader
+			}
+			fields[len(fields)-1] += "\r\n" + line
+		} else {
+			if strings.IndexByte(line, ':') <= 0 {
+				return nil, errMalformedHeader
+			}
+			fields = append(fields, line)
+		}
+		if err == io.EOF {
+			return fields, nil
+		}
+	}
+}
+
 // Header processes a single header line.
 // May be invoked multiple times.
 func (d *Dkim) Header(line string) Status {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return Status(StatusINVALID)
+	}
+
+	if d.preview != nil {
+		d.preview.hdrs = append(d.preview.hdrs, line)
+	}
+	if i := strings.IndexByte(line, ':'); i > 0 && strings.EqualFold(strings.TrimSpace(line[:i]), "from") {
+		if d.nfrom == 0 {
+			d.from = strings.TrimSpace(strings.ReplaceAll(line[i+1:], "\r\n", ""))
+		}
+		d.nfrom++
+	}
+
 	data := []byte(line)
-	return Status(C.dkim_header(d.dkim, (*C.u_char)(unsafe.Pointer(&data[0])), C.size_t(len(data))))
+	return Status(C.dkim_header(d.dkim, bytePtr(data), C.size_t(len(data))))
+}
+
+// HeaderField processes a single header field given as name and value,
+// as a milter receives it, with the leading space of the value removed.
+// It is passed on as "name: value"; bare LF line endings of folded values
+// are converted to CRLF.
+//
+// Together with Eoh, Body and Eom, this maps the milter callbacks
+// one to one: header, eoh, body for each chunk and eom. Each step returns
+// the library's status; GetError describes why it failed.
+func (d *Dkim) HeaderField(name, value string) Status {
+	return d.Header(name + ": " + string(fixCRLF([]byte(value))))
 }
 
 // Eoh is called to signal end of header.
 func (d *Dkim) Eoh() Status {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return Status(StatusINVALID)
+	}
+
+	d.enter()
+	defer d.leave()
+
 	return Status(C.dkim_eoh(d.dkim))
 }
 
 // Body processes the message body.
 func (d *Dkim) Body(data []byte) Status {
-	return Status(C.dkim_body(d.dkim, (*C.u_char)(unsafe.Pointer(&data[0])), C.size_t(len(data))))
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return Status(StatusINVALID)
+	}
+
+	if d.preview != nil {
+		d.preview.body.Write(data)
+	}
+
+	return Status(C.dkim_body(d.dkim, bytePtr(data), C.size_t(len(data))))
 }
 
 // Eom is called to signal end of message.
+// If testKey is not nil, it is set as by EomResult.
 func (d *Dkim) Eom(testKey *bool) Status {
-	return Status(C.dkim_eom(d.dkim, (*C._Bool)(testKey)))
+	tk, stat := d.EomResult()
+	if testKey != nil {
+		*testKey = tk
+	}
+	return stat
 }
 
-// Chunk processes a chunk of message data.
-// Can include header and body data.
-//
-// TODO: disabled until I figure out what's fould here
-//
-// func (d *Dkim) Chunk(data []byte) error {
-// 	var stat C.DKIM_STAT
-// 	stat = C.dkim_chunk(d.dkim, (*C.u_char)(unsafe.Pointer(&data[0])), C.size_t(len(data)))
-// 	if stat != StatusOK {
-// 		return fmt.Errorf("error processing chunk (%s)", getErr(stat))
-// 	}
-// 	return nil
-// }
+// EomResult signals the end of message like Eom. For a verifier, testKey
+// reports whether the key of the signature GetSignature returns is
+// flagged t=y, i.e. the domain is only testing DKIM.
+func (d *Dkim) EomResult() (testKey bool, stat Status) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
 
-// GetSigHdr computes the signature header for a message.
-func (d *Dkim) GetSigHdr() (string, Status) {
-	var buf = make([]byte, 1024)
-	stat := Status(C.dkim_getsighdr(d.dkim, (*C.u_char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)), C.size_t(0)))
-	if stat != StatusOK {
-		return "", stat
-	}
-	i := bytes.Index(buf, []byte{0x0})
-	if i >= 0 {
-		return string(buf[:i]), stat
+	if d.dkim == nil {
+		return false, StatusINVALID
 	}
-	return string(buf), stat
+
+	d.enter()
+	defer d.leave()
+
+	var tk C._Bool
+	stat = d.eom(&tk)
+	return bool(tk), stat
 }
 
-// GetSignature returns the signature.
-// Eom must be called before invoking GetSignature.
-func (d *Dkim) GetSignature() *Signature {
-	var sig *C.DKIM_SIGINFO
-	sig = C.dkim_getsignature(d.dkim)
-	if sig == nil {
-		return nil
+// eom signals the end of message to the library and applies the Lib's
+// DNSSEC requirement to the result. d.mtx must be held.
+func (d *Dkim) eom(testKey *C._Bool) Status {
+	stat := Status(C.dkim_eom(d.dkim, testKey))
+	if d.signing || d.lib == nil {
+		return stat
 	}
-	return &Signature{
-		h:   d,
-		sig: sig,
+	d.lib.mtx.Lock()
+	mode := d.lib.dnssec
+	d.lib.mtx.Unlock()
+
+	if mode == DNSSECModeNone {
+		return stat
+	}
+	var sigs **C.DKIM_SIGINFO
+	var n C.int
+	if C.dkim_getsiglist(d.dkim, &sigs, &n) != C.DKIM_STAT_OK || n <= 0 {
+		return stat
+	}
+	rejected := make(map[*C.DKIM_SIGINFO]bool)
+	passed := false
+	for _, sig := range unsafe.Slice(sigs, int(n)) {
+		if Sigflag(C.dkim_sig_getflags(sig))&SigflagPASSED == 0 {
+			continue
+		}
+		if !mode.accepts(DNSSEC(C.dkim_sig_getdnssec(sig))) {
+			rejected[sig] = true
+		} else {
+			passed = true
+		}
 	}
-}
 
-// GetError gets the last error for the dkim handle
-func (d *Dkim) GetError() string {
-	return C.GoString(C.dkim_geterror(d.dkim))
+	d.sigMtx.Lock()
+	d.rejected = rejected
+	d.sigMtx.Unlock()
+
+	if stat == StatusOK && !passed {
+		return StatusCANTVRFY
+	}
+	return stat
 }
 
-// Destroy destroys the dkim handle.
-func (d *Dkim) Destroy() Status {
+// SetSignatureTagValues adds extension tags to the signature generated
+// by a signing handle. Tags are added in lexical order. The library
+// rejects tags defined by RFC 6376, like b= or d=, and malformed tags or
+// values with StatusINVALID, in which case the remaining tags aren't
+// added. Must be called before Eom.
+func (d *Dkim) SetSignatureTagValues(tags map[string]string) Status {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 
-	if d.dkim != nil {
-		stat := Status(C.dkim_free(d.dkim))
+	if d.dkim == nil {
+		return StatusINVALID
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		ctag := C.CString(k)
+		cval := C.CString(tags[k])
+		stat := Status(C.dkim_add_xtag(d.dkim, ctag, cval))
+		C.free(unsafe.Pointer(ctag))
+		C.free(unsafe.Pointer(cval))
 		if stat != StatusOK {
 			return stat
 		}
-		d.dkim = nil
 	}
-	return Status(StatusOK)
+	return StatusOK
 }
 
-// Signature is a DKIM signature
-type Signature struct {
-	h   *Dkim
-	sig *C.DKIM_SIGINFO
-}
+// SetPartial makes a signing handle add an l= tag with the length of the
+// canonicalized body it signed. The whole body is still signed, but
+// content appended to it later, like a mailing list footer, doesn't break
+// the signature. It only affects d; with LibflagsSIGNLEN set on the
+// library handle, every signature carries l= regardless. Must be called
+// before Eom.
+func (d *Dkim) SetPartial(partial bool) Status {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
 
-// Process processes a signature for validity.
-func (s *Signature) Process() Status {
-	return Status(C.dkim_sig_process(s.h.dkim, s.sig))
+	if d.dkim == nil {
+		return StatusINVALID
+	}
+	return Status(C.dkim_setpartial(d.dkim, C._Bool(partial)))
 }
 
-// Flags returns the signature flags
+// Partial reports whether SetPartial is in effect.
+func (d *Dkim) Partial() bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return false
+	}
+	return bool(C.dkim_getpartial(d.dkim))
+}
+
+// SetMargin sets the column at which GetSigHdr folds the signature
+// header, counting tabs as eight columns. The library default is 75; 0
+// disables folding. Must be called before GetSigHdr.
+func (d *Dkim) SetMargin(cols int) Status {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil || cols < 0 {
+		return StatusINVALID
+	}
+	return Status(C.dkim_set_margin(d.dkim, C.int(cols)))
+}
+
+// SetSigner sets the signing identity, which the signature carries in
+// its i= tag instead of the default "@domain". It must be an address in
+// the signing domain or a subdomain of it. Must be called before Eom.
+func (d *Dkim) SetSigner(signer string) Status {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return StatusINVALID
+	}
+	csigner := C.CString(signer)
+	defer C.free(unsafe.Pointer(csigner))
+
+	return Status(C.dkim_set_signer(d.dkim, (*C.u_char)(unsafe.Pointer(csigner))))
+}
+
+// Signer returns the signing identity set with SetSigner, or an empty
+// string if none was set.
+func (d *Dkim) Signer() string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return ""
+	}
+	return goString(C.dkim_get_signer(d.dkim))
+}
+
+// MinBody returns how many more body bytes the library needs before all
+// body hashes are complete. Once it returns 0, e.g. because every
+// signature carries an l= limit that has been reached, callers may stop
+// passing body data and call Eom.
+func (d *Dkim) MinBody() uint {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return 0
+	}
+	return uint(C.dkim_minbody(d.dkim))
+}
+
+// EomContext is like Eom, but returns StatusCBTRYAGAIN as soon as ctx is
+// done instead of waiting for slow key lookups. The library call itself
+// can't be interrupted and finishes in the background; Destroy blocks
+// until it has. The library's own DNS timeout, see Lib.SetTimeout, is
+// left alone, as it applies to every handle of the library.
+func (d *Dkim) EomContext(ctx context.Context, testKey *bool) Status {
+	if ctx.Err() != nil {
+		return Status(StatusCBTRYAGAIN)
+	}
+	type result struct {
+		stat    Status
+		testKey bool
+	}
+	done := make(chan result, 1)
+	go func() {
+		d.mtx.Lock()
+		defer d.mtx.Unlock()
+
+		if d.dkim == nil {
+			done <- result{stat: Status(StatusINVALID)}
+			return
+		}
+		d.enter()
+		defer d.leave()
+
+		var tk C._Bool
+		stat := d.eom(&tk)
+		done <- result{stat, bool(tk)}
+	}()
+
+	select {
+	case res := <-done:
+		if testKey != nil {
+			*testKey = res.testKey
+		}
+		return res.stat
+	case <-ctx.Done():
+		return Status(StatusCBTRYAGAIN)
+	}
+}
+
+// RetryPolicy controls how EomWithRetry retries transient failures.
+type RetryPolicy struct {
+	Attempts   int           // maximum number of Eom calls; at least one is made
+	Backoff    time.Duration // delay before the first retry, doubled for each retry
+	MaxBackoff time.Duration // upper bound of the delay, 0 for none
+}
+
+// EomWithRetry is like Eom, but calls Eom again according to policy as
+// long as it fails with StatusMULTIDNSREPLY or StatusCBTRYAGAIN, e.g.
+// because a key lookup callback asked to try again.
+//
+// These statuses are transient. If they are still returned after the
+// last attempt, the message should be deferred, not permanently rejected.
+func (d *Dkim) EomWithRetry(policy RetryPolicy, testKey *bool) Status {
+	delay := policy.Backoff
+	for i := 1; ; i++ {
+		stat := d.Eom(testKey)
+		if (stat != StatusMULTIDNSREPLY && stat != StatusCBTRYAGAIN) || i >= policy.Attempts {
+			return stat
+		}
+		time.Sleep(delay)
+		delay *= 2
+		if policy.MaxBackoff > 0 && delay > policy.MaxBackoff {
+			delay = policy.MaxBackoff
+		}
+	}
+}
+
+// Chunk processes a chunk of message data.
+// Can include header and body data, so a raw message can be fed without
+// splitting it first. May be invoked multiple times.
+//
+// Once all data has been passed, Chunk must be called with an empty
+// chunk to flush the input, followed by Eom.
+func (d *Dkim) Chunk(data []byte) Status {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return Status(StatusINVALID)
+	}
+
+	d.enter()
+	defer d.leave()
+
+	if d.preview != nil {
+		d.preview.chunks.Write(data)
+	}
+
+	if len(data) == 0 {
+		return Status(C.dkim_chunk(d.dkim, nil, 0))
+	}
+	return Status(C.dkim_chunk(d.dkim, bytePtr(data), C.size_t(len(data))))
+}
+
+// maxSigHdrLen bounds the buffer GetSigHdr grows to.
+const maxSigHdrLen = 1 << 20
+
+// sigHdrPrefix precedes the signature header value in a message.
+const sigHdrPrefix = `DKIM-Signature: `
+
+// sigField returns the signature header field for a value returned by
+// GetSigHdr. Whichever line ending the library folds the value with,
+// every line of the field ends in a single CRLF.
+func sigField(v string) string {
+	v = strings.TrimRight(v, "\r\n")
+	v = strings.ReplaceAll(v, "\r\n", "\n")
+	v = strings.ReplaceAll(v, "\n", "\r\n")
+	return sigHdrPrefix + v + "\r\n"
+}
+
+// GetSigHdr computes the signature header for a message.
+// The buffer is grown until the header fits.
+func (d *Dkim) GetSigHdr() (string, Status) {
+	return d.GetSigHdrMargin(len(sigHdrPrefix))
+}
+
+// GetSigHdrMargin is like GetSigHdr, but lets the caller specify how many
+// characters precede the value on its first line, e.g. the length of the
+// header name and colon, so lines are folded at the right width.
+func (d *Dkim) GetSigHdrMargin(initial int) (string, Status) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return "", Status(StatusINVALID)
+	}
+
+	var buf []byte
+	var stat Status
+	for n := 1024; ; n *= 2 {
+		buf = make([]byte, n)
+		stat = Status(C.dkim_getsighdr(d.dkim, (*C.u_char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf)), C.size_t(initial)))
+		if stat != StatusNORESOURCE || n >= maxSigHdrLen {
+			break
+		}
+	}
+	if stat != StatusOK {
+		return "", stat
+	}
+	i := bytes.Index(buf, []byte{0x0})
+	if i >= 0 {
+		return string(buf[:i]), stat
+	}
+	return string(buf), stat
+}
+
+// SigHdrZeroCopy is like GetSigHdr, but has the library render the header
+// into a buffer of its own, sized as needed, instead of a buffer passed
+// in, so there is no guessing of the size. The buffer is owned by the
+// handle; the returned string is a copy of it.
+func (d *Dkim) SigHdrZeroCopy() (string, Status) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return "", StatusINVALID
+	}
+
+	var buf *C.u_char
+	var n C.size_t
+	stat := Status(C.dkim_getsighdr_d(d.dkim, C.size_t(len(sigHdrPrefix)), &buf, &n))
+	if stat != StatusOK {
+		return "", stat
+	}
+	return C.GoStringN((*C.char)(unsafe.Pointer(buf)), C.int(n)), StatusOK
+}
+
+// GetSignature returns the signature.
+// Eom must be called before invoking GetSignature.
+func (d *Dkim) GetSignature() *Signature {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return nil
+	}
+
+	var sig *C.DKIM_SIGINFO
+	sig = C.dkim_getsignature(d.dkim)
+	if sig == nil {
+		return nil
+	}
+	return &Signature{
+		h:   d,
+		sig: sig,
+	}
+}
+
+// GetSignatures returns all signatures found on the message.
+// Eom must be called before invoking GetSignatures.
+func (d *Dkim) GetSignatures() ([]*Signature, Status) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return nil, Status(StatusINVALID)
+	}
+
+	var sigs **C.DKIM_SIGINFO
+	var n C.int
+	stat := Status(C.dkim_getsiglist(d.dkim, &sigs, &n))
+	if stat != StatusOK {
+		return nil, stat
+	}
+	res := make([]*Signature, 0, int(n))
+	for _, sig := range unsafe.Slice(sigs, int(n)) {
+		res = append(res, &Signature{
+			h:   d,
+			sig: sig,
+		})
+	}
+	return res, stat
+}
+
+// Identity returns the signing identity (i=) the library resolved for
+// the message, which is the one of the signature GetSignature returns.
+// StatusNOSIG is returned if there is no such signature (yet).
+func (d *Dkim) Identity() (string, Status) {
+	sig := d.GetSignature()
+	if sig == nil {
+		return "", Status(StatusNOSIG)
+	}
+	return sig.identity()
+}
+
+// Domain returns the domain the library associates with the message. For
+// a verifier, that is the domain of the From header, as determined at Eoh;
+// for a signer, the signing domain.
+func (d *Dkim) Domain() string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return ""
+	}
+	return goString(C.dkim_getdomain(d.dkim))
+}
+
+// User returns the local part of the From header address of a verified
+// message, as determined at Eoh.
+func (d *Dkim) User() string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return ""
+	}
+	return goString(C.dkim_getuser(d.dkim))
+}
+
+// maxOriginalHeaders bounds the number of z= entries OriginalHeaders
+// makes room for.
+const maxOriginalHeaders = 1024
+
+// OriginalHeaders returns the header fields copied into the z= tag of the
+// signature GetSignature returns, i.e. the headers as they were when the
+// message was signed. Signers add z= if LibflagsZTAGS is set.
+func (d *Dkim) OriginalHeaders() ([]string, Status) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return nil, Status(StatusINVALID)
+	}
+	sig := C.dkim_getsignature(d.dkim)
+	if sig == nil {
+		return nil, Status(StatusNOSIG)
+	}
+	for n := 64; ; n *= 2 {
+		ptrs := (**C.u_char)(C.calloc(C.size_t(n), C.size_t(unsafe.Sizeof((*C.u_char)(nil)))))
+		cnt := C.int(n)
+		stat := Status(C.dkim_ohdrs(d.dkim, sig, ptrs, &cnt))
+		if stat == StatusNORESOURCE && n < maxOriginalHeaders {
+			C.free(unsafe.Pointer(ptrs))
+			continue
+		}
+		var hdrs []string
+		if stat == StatusOK {
+			for _, p := range unsafe.Slice(ptrs, int(cnt)) {
+				hdrs = append(hdrs, goString((*C.uchar)(p)))
+			}
+		}
+		C.free(unsafe.Pointer(ptrs))
+		return hdrs, stat
+	}
+}
+
+// HeaderDiff is a header field that changed after signing.
+type HeaderDiff struct {
+	Old string // as signed
+	New string // as received
+}
+
+// DiffHeaders compares the original headers recorded in z= (see
+// OriginalHeaders) with the received ones and returns the fields that
+// changed. maxcost limits the edit distance up to which two fields are
+// considered the same field modified. The library has to be built with
+// diffheaders support, otherwise StatusNOTIMPLEMENT is returned.
+func (d *Dkim) DiffHeaders(maxcost int) ([]HeaderDiff, Status) {
+	ohdrs, stat := d.OriginalHeaders()
+	if stat != StatusOK {
+		return nil, stat
+	}
+	sig := d.GetSignature()
+	if sig == nil {
+		return nil, Status(StatusNOSIG)
+	}
+	canon, _ := sig.Canonicalizations()
+
+	carr := cStringArray(ohdrs)
+	defer freeCStringArray(carr)
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return nil, Status(StatusINVALID)
+	}
+	var out *C.struct_dkim_hdrdiff
+	var nout C.int
+	stat = Status(C.dkim_diffheaders(d.dkim, C.dkim_canon_t(canon), C.int(maxcost), carr, C.int(len(ohdrs)), &out, &nout))
+	if stat != StatusOK {
+		return nil, stat
+	}
+	defer C.free(unsafe.Pointer(out))
+
+	var diffs []HeaderDiff
+	for _, hd := range unsafe.Slice(out, int(nout)) {
+		diffs = append(diffs, HeaderDiff{
+			Old: goString((*C.uchar)(hd.hd_old)),
+			New: goString((*C.uchar)(hd.hd_new)),
+		})
+	}
+	return diffs, stat
+}
+
+// MessageDate returns the date of the message's Date header, as parsed
+// by the library. ok is false if the message has no (parsable) Date
+// header, or the header hasn't been processed yet.
+func (d *Dkim) MessageDate() (date time.Time, ok bool) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return time.Time{}, false
+	}
+	t := C.dkim_get_msgdate(d.dkim)
+	if t == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(t), 0), true
+}
+
+// GetError gets the last error for the dkim handle
+func (d *Dkim) GetError() string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return ""
+	}
+
+	return C.GoString(C.dkim_geterror(d.dkim))
+}
+
+// Destroy destroys the dkim handle.
+func (d *Dkim) Destroy() Status {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	d.sigMtx.Lock()
+	defer d.sigMtx.Unlock()
+
+	if d.dkim != nil {
+		stat := Status(C.dkim_free(d.dkim))
+		if stat != StatusOK {
+			return stat
+		}
+		releaseContexts(d.dkim)
+		d.dkim = nil
+	}
+	return Status(StatusOK)
+}
+
+// Signature is a DKIM signature
+//
+// A Signature belongs to the handle it was obtained from and is only
+// valid as long as the handle is. Once the handle has been destroyed,
+// methods returning a Status return StatusINVALID and the others return
+// zero values.
+type Signature struct {
+	h   *Dkim
+	sig *C.DKIM_SIGINFO
+}
+
+// hold keeps the owning handle from being destroyed and reports whether
+// it is still alive. If it returns true, release must be called.
+func (s *Signature) hold() bool {
+	s.h.sigMtx.RLock()
+	if s.h.dkim == nil {
+		s.h.sigMtx.RUnlock()
+		return false
+	}
+	return true
+}
+
+func (s *Signature) release() {
+	s.h.sigMtx.RUnlock()
+}
+
+// Process processes a signature for validity, retrieving its key if it
+// wasn't yet. Like the handle's methods, it must not be called from a
+// callback.
+func (s *Signature) Process() Status {
+	d := s.h
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return StatusINVALID
+	}
+	d.enter()
+	defer d.leave()
+
+	return Status(C.dkim_sig_process(d.dkim, s.sig))
+}
+
+// Ignore marks the signature to be skipped by the library. Eom neither
+// fetches its key nor verifies it, and it doesn't affect the result.
+// Ignore must be called before the signature is processed, e.g. from a
+// prescreen callback; it has no effect afterwards.
+func (s *Signature) Ignore() {
+	if !s.hold() {
+		return
+	}
+	defer s.release()
+
+	C.dkim_sig_ignore(s.sig)
+}
+
+// Flags returns the signature flags
 func (s *Signature) Flags() Sigflag {
+	if !s.hold() {
+		return 0
+	}
+	defer s.release()
+
 	var res C.uint
 	res = C.dkim_sig_getflags(s.sig)
+	if s.h.rejected[s.sig] {
+		// see SetDNSSECMode
+		return Sigflag(res) &^ SigflagPASSED
+	}
 	return Sigflag(res)
 }
 
+// dnssecRejected reports whether the signature passed, but its key
+// doesn't meet the DNSSEC requirement set with SetDNSSECMode.
+func (s *Signature) dnssecRejected() bool {
+	if !s.hold() {
+		return false
+	}
+	defer s.release()
+
+	return s.h.rejected[s.sig]
+}
+
+// Processed reports whether the signature has been evaluated, i.e. it
+// wasn't ignored and its key could be retrieved.
+func (s *Signature) Processed() bool {
+	return s.Flags()&SigflagPROCESSED != 0
+}
+
+// Passed reports whether the header signature verified. The body hash
+// is checked separately, see BodyHashResult.
+func (s *Signature) Passed() bool {
+	return s.Flags()&SigflagPASSED != 0
+}
+
+// KeyLoaded reports whether the signing key was retrieved.
+func (s *Signature) KeyLoaded() bool {
+	return s.Flags()&SigflagKEYLOADED != 0
+}
+
+// IsTestKey reports whether the key record is flagged t=y, meaning the
+// domain is testing DKIM and failures shouldn't be acted upon.
+func (s *Signature) IsTestKey() bool {
+	return s.Flags()&SigflagTESTKEY != 0
+}
+
+// NoSubdomain reports whether the key record is flagged t=s, meaning the
+// i= domain must equal the d= domain.
+func (s *Signature) NoSubdomain() bool {
+	return s.Flags()&SigflagNOSUBDOMAIN != 0
+}
+
+// BodyHashResult returns the result of the body hash comparison.
+// A mismatch means the body was altered, independent of whether the
+// header signature itself is valid.
+func (s *Signature) BodyHashResult() BodyHash {
+	if !s.hold() {
+		return BodyHashUNTESTED
+	}
+	defer s.release()
+
+	return BodyHash(C.dkim_sig_getbh(s.sig))
+}
+
+// SignedBodyLen returns the number of canonicalized body bytes the
+// signature covers. For a signature with an l= tag, this is its value,
+// and content may have been appended to the body without invalidating
+// the signature; see TagValue.
+func (s *Signature) SignedBodyLen() (int64, Status) {
+	if !s.hold() {
+		return 0, StatusINVALID
+	}
+	defer s.release()
+
+	var canonlen, signlen C.ssize_t
+	stat := Status(C.dkim_sig_getcanonlen(s.h.dkim, s.sig, nil, &canonlen, &signlen))
+	if stat != StatusOK {
+		return 0, stat
+	}
+	if signlen < 0 {
+		return int64(canonlen), stat
+	}
+	return int64(signlen), stat
+}
+
+// CanonicalizedBodyLen returns the number of body bytes canonicalized
+// and hashed for the signature, which never exceeds an l= limit. ok is
+// false if the body hasn't been processed for this signature.
+func (s *Signature) CanonicalizedBodyLen() (n int64, ok bool) {
+	if !s.hold() {
+		return 0, false
+	}
+	defer s.release()
+
+	var canonlen C.ssize_t
+	if Status(C.dkim_sig_getcanonlen(s.h.dkim, s.sig, nil, &canonlen, nil)) != StatusOK {
+		return 0, false
+	}
+	return int64(canonlen), true
+}
+
+// Canonicalizations returns the header and body canonicalization methods
+// used by the signature.
+func (s *Signature) Canonicalizations() (hdr, body Canon) {
+	if !s.hold() {
+		return CanonUNKNOWN, CanonUNKNOWN
+	}
+	defer s.release()
+
+	var h, b C.dkim_canon_t
+	if Status(C.dkim_sig_getcanons(s.sig, &h, &b)) != StatusOK {
+		return CanonUNKNOWN, CanonUNKNOWN
+	}
+	return Canon(h), Canon(b)
+}
+
+// Algorithm returns the signing algorithm used by the signature.
+func (s *Signature) Algorithm() Sign {
+	if !s.hold() {
+		return SignUNKNOWN
+	}
+	defer s.release()
+
+	var alg C.dkim_alg_t
+	if Status(C.dkim_sig_getsignalg(s.sig, &alg)) != StatusOK {
+		return SignUNKNOWN
+	}
+	return Sign(alg)
+}
+
+// KeySize returns the size in bits of the key used to verify the
+// signature. It fails if the key hasn't been loaded yet.
+func (s *Signature) KeySize() (int, Status) {
+	if !s.hold() {
+		return 0, StatusINVALID
+	}
+	defer s.release()
+
+	var bits C.uint
+	stat := Status(C.dkim_sig_getkeysize(s.sig, &bits))
+	if stat != StatusOK {
+		return 0, stat
+	}
+	return int(bits), stat
+}
+
+// ReportInfo holds the failure reporting parameters (RFC 6651) published
+// by a signing domain.
+type ReportInfo struct {
+	Address string // address to send reports to
+	Options string // requested report types (rr=)
+	SMTP    string // SMTP error string to use on rejection (rs=)
+	Percent uint   // percentage of failures to report (rp=)
+}
+
+// maxReportInfoLen bounds the fields of ReportInfo.
+const maxReportInfoLen = 1024
+
+// ReportInfo returns the failure reporting parameters for the
+// signature's domain. The domain's reporting record is only looked up if
+// the key record requests reports with r=y; otherwise all fields are
+// empty.
+func (s *Signature) ReportInfo() (ReportInfo, Status) {
+	if !s.hold() {
+		return ReportInfo{}, StatusINVALID
+	}
+	defer s.release()
+
+	var addr, opts, smtp [maxReportInfoLen]C.uchar
+	var pct C.u_int
+	stat := Status(C.dkim_sig_getreportinfo(s.h.dkim, s.sig, nil, nil,
+		&addr[0], C.size_t(len(addr)),
+		&opts[0], C.size_t(len(opts)),
+		&smtp[0], C.size_t(len(smtp)),
+		&pct))
+	if stat != StatusOK {
+		return ReportInfo{}, stat
+	}
+	return ReportInfo{
+		Address: goString(&addr[0]),
+		Options: goString(&opts[0]),
+		SMTP:    goString(&smtp[0]),
+		Percent: uint(pct),
+	}, stat
+}
+
+// Err returns the error code recorded for the signature.
+func (s *Signature) Err() SigError {
+	if !s.hold() {
+		return SigErrorUNKNOWN
+	}
+	defer s.release()
+
+	return SigError(C.dkim_sig_geterror(s.sig))
+}
+
+// ErrString returns a human readable description of the error
+// recorded for the signature.
+func (s *Signature) ErrString() string {
+	return C.GoString(C.dkim_sig_geterrorstr(C.DKIM_SIGERROR(s.Err())))
+}
+
+// DNSSEC returns the DNSSEC status of the key lookup for the signature.
+// Meaningful values are only reported if the library was built with a
+// DNSSEC-aware resolver, otherwise this is always DNSSECUnknown.
+func (s *Signature) DNSSEC() DNSSEC {
+	if !s.hold() {
+		return DNSSECUnknown
+	}
+	defer s.release()
+
+	return DNSSEC(C.dkim_sig_getdnssec(s.sig))
+}
+
+// HeaderSigned reports whether the named header field is covered by the
+// signature (listed in h=).
+func (s *Signature) HeaderSigned(name string) bool {
+	if !s.hold() {
+		return false
+	}
+	defer s.release()
+
+	cname := C.CString(strings.ToLower(strings.TrimSpace(name)))
+	defer C.free(unsafe.Pointer(cname))
+
+	return bool(C.dkim_sig_hdrsigned(s.sig, (*C.u_char)(unsafe.Pointer(cname))))
+}
+
+// TagValue returns the raw value of a tag in the signature, e.g. "t" or
+// "bh". The boolean is false if the tag is not present.
+func (s *Signature) TagValue(tag string) (string, bool) {
+	if !s.hold() {
+		return "", false
+	}
+	defer s.release()
+
+	ctag := C.CString(tag)
+	defer C.free(unsafe.Pointer(ctag))
+
+	v := C.dkim_sig_gettagvalue(s.sig, C._Bool(false), (*C.u_char)(unsafe.Pointer(ctag)))
+	if v == nil {
+		return "", false
+	}
+	return goString(v), true
+}
+
+// Timestamp returns the signing time (t=) of the signature. ok is false
+// if the signature has no valid t= tag.
+func (s *Signature) Timestamp() (t time.Time, ok bool) {
+	return s.timeTag("t")
+}
+
+// Expiry returns the expiration time (x=) of the signature. ok is false
+// if the signature has no valid x= tag, i.e. it doesn't expire.
+func (s *Signature) Expiry() (t time.Time, ok bool) {
+	return s.timeTag("x")
+}
+
+// Expired reports whether the signature has expired at now. Signatures
+// without an expiration time never expire.
+func (s *Signature) Expired(now time.Time) bool {
+	x, ok := s.Expiry()
+	return ok && now.After(x)
+}
+
+// timeTag parses a tag holding seconds since the epoch.
+func (s *Signature) timeTag(tag string) (time.Time, bool) {
+	v, ok := s.TagValue(tag)
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(n, 0), true
+}
+
+// ATPSCheck checks whether the author domain of the message authorizes
+// the signing domain as a third-party signer (RFC 6541). The library has
+// to be built with ATPS support, otherwise StatusNOTIMPLEMENT is returned.
+func (s *Signature) ATPSCheck() (ATPSResult, Status) {
+	if !s.hold() {
+		return ATPSUnknown, StatusINVALID
+	}
+	defer s.release()
+
+	res := C.dkim_atps_t(ATPSUnknown)
+	stat := Status(C.dkim_atps_check(s.h.dkim, s.sig, nil, &res))
+	return ATPSResult(res), stat
+}
+
+// Domain returns the signing domain (d=) of the signature.
+func (s *Signature) Domain() string {
+	if !s.hold() {
+		return ""
+	}
+	defer s.release()
+
+	return goString(C.dkim_sig_getdomain(s.sig))
+}
+
+// Selector returns the selector (s=) of the signature.
+func (s *Signature) Selector() string {
+	if !s.hold() {
+		return ""
+	}
+	defer s.release()
+
+	return goString(C.dkim_sig_getselector(s.sig))
+}
+
+// Identity returns the signing identity (i=) of the signature.
+// If the signature has no i= tag, the library's default of "@" plus
+// the signing domain is returned.
+func (s *Signature) Identity() string {
+	id, _ := s.identity()
+	return id
+}
+
+func (s *Signature) identity() (string, Status) {
+	if !s.hold() {
+		return "", StatusINVALID
+	}
+	defer s.release()
+
+	for n := 256; ; n *= 2 {
+		buf := make([]byte, n)
+		stat := Status(C.dkim_sig_getidentity(s.h.dkim, s.sig, (*C.u_char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf))))
+		if stat == StatusNORESOURCE && n < maxSigHdrLen {
+			continue
+		}
+		if stat != StatusOK {
+			return "", stat
+		}
+		if i := bytes.IndexByte(buf, 0); i >= 0 {
+			buf = buf[:i]
+		}
+		return string(buf), stat
+	}
+}
+
+// goString converts a NUL-terminated string returned by the library.
+// A NULL pointer yields an empty string.
+func goString(p *C.uchar) string {
+	if p == nil {
+		return ""
+	}
+	return C.GoString((*C.char)(unsafe.Pointer(p)))
+}
+
+// bytePtr returns a pointer to the first byte of data. For empty data it
+// points to a scratch byte instead, so the library gets a valid pointer
+// along with a zero length.
+func bytePtr(data []byte) *C.u_char {
+	if len(data) == 0 {
+		var scratch [1]byte
+		return (*C.u_char)(unsafe.Pointer(&scratch[0]))
+	}
+	return (*C.u_char)(unsafe.Pointer(&data[0]))
+}
+
 func getErr(s C.DKIM_STAT) string {
 	return Status(s).Error()
 }
@@ -395,3 +1891,55 @@ func (s Status) String() string {
 func (s Status) Error() string {
 	return s.String()
 }
+
+// StatusError is a failure status along with the error detail recorded
+// by the handle, e.g. "NOKEY: no key for selector foo". Use errors.Is to
+// match the status.
+type StatusError struct {
+	Status Status
+	Detail string // handle error text, see GetError
+}
+
+func (e *StatusError) Error() string {
+	if e.Detail == "" {
+		return e.Status.Error()
+	}
+	return e.Status.name() + ": " + e.Detail
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Status
+}
+
+// statusError returns stat with the handle's error detail as error, or
+// nil if stat is StatusOK. It must not be called with d.mtx held.
+func (d *Dkim) statusError(stat Status) error {
+	if stat == StatusOK {
+		return nil
+	}
+	return &StatusError{Status: stat, Detail: d.GetError()}
+}
+
+// IsOK reports whether s is StatusOK.
+func (s Status) IsOK() bool {
+	return s == StatusOK
+}
+
+// IsTempFail reports whether s is a transient failure, after which the
+// message should be deferred rather than rejected.
+func (s Status) IsTempFail() bool {
+	switch s {
+	case StatusNORESOURCE, StatusKEYFAIL, StatusCBTRYAGAIN, StatusMULTIDNSREPLY:
+		return true
+	}
+	return false
+}
+
+// IsPermFail reports whether s is a permanent verification failure.
+func (s Status) IsPermFail() bool {
+	switch s {
+	case StatusBADSIG, StatusNOSIG, StatusNOKEY, StatusREVOKED, StatusSYNTAX:
+		return true
+	}
+	return false
+}
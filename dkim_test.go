@@ -1,9 +1,27 @@
 package opendkim
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unsafe"
 )
 
 var msgHdr = map[string]string{
@@ -58,6 +76,17 @@ zHxse/re+1V/o5Qsx4gdUT/+exdxtw0gf0zEuG0MYBwGYHgAySlWiAiZ3/it5upW
 nSZOSkTBu27e+ZRMa+5VEZchWazUlixTxvPl6T7dK1kVPZ5vRioFSA==
 -----END RSA PRIVATE KEY-----`
 
+// testLib returns a library handle that looks up keys in the bundled
+// key file, so tests don't depend on DNS.
+func testLib(t *testing.T) *Lib {
+	lib := Init()
+	if stat := lib.SetQueryMethodFile("testdata/keys"); stat != StatusOK {
+		lib.Close()
+		t.Fatal(stat)
+	}
+	return lib
+}
+
 func process(hdr map[string]string, body string, d *Dkim, t *testing.T) {
 	for h, line := range hdr {
 		stat := d.Header(h + `: ` + line)
@@ -93,7 +122,7 @@ func createMsg(hdr map[string]string, body string) []byte {
 }
 
 func TestSignAndVerify(t *testing.T) {
-	lib := Init()
+	lib := testLib(t)
 	defer lib.Close()
 
 	d, stat := lib.NewSigner(
@@ -154,13 +183,6 @@ func TestSignAndVerify(t *testing.T) {
 	}
 	flags := sig.Flags()
 
-	/*
-		assert((flags & DKIM_SIGFLAG_PROCESSED) != 0);
-		assert((flags & DKIM_SIGFLAG_IGNORE) == 0);
-		assert((flags & DKIM_SIGFLAG_PASSED) != 0);
-		assert(dkim_sig_getbh(sigs[0]) == DKIM_SIGBH_MATCH);
-	*/
-
 	if x := (flags & SigflagPROCESSED); x == 0 {
 		t.Fatal(x)
 	}
@@ -170,10 +192,13 @@ func TestSignAndVerify(t *testing.T) {
 	if x := (flags & SigflagPASSED); x == 0 {
 		t.Fatal(x)
 	}
+	if x := sig.BodyHashResult(); x != BodyHashMATCH {
+		t.Fatal(x)
+	}
 }
 
 func TestSignAndVerifyHelper(t *testing.T) {
-	lib := Init()
+	lib := testLib(t)
 	defer lib.Close()
 
 	msg := createMsg(msgHdr, msgBody)
@@ -228,3 +253,1735 @@ func TestSignAndVerifyHelper(t *testing.T) {
 		t.Fatal(stat)
 	}
 }
+
+func rss(t *testing.T) int64 {
+	b, err := os.ReadFile("/proc/self/statm")
+	if err != nil {
+		t.Skip("rss not available:", err)
+	}
+	f := strings.Fields(string(b))
+	if len(f) < 2 {
+		t.Skip("unexpected statm format")
+	}
+	pages, err := strconv.ParseInt(f[1], 10, 64)
+	if err != nil {
+		t.Skip(err)
+	}
+	return pages * int64(os.Getpagesize())
+}
+
+func TestNewSignerDoesNotLeak(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := createMsg(msgHdr, msgBody)
+	sign := func(n int) {
+		for i := 0; i < n; i++ {
+			d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+			if stat != StatusOK {
+				t.Fatal(stat)
+			}
+			if _, err := d.Sign(bytes.NewReader(msg)); err != nil {
+				t.Fatal(err)
+			}
+			d.Destroy()
+		}
+	}
+
+	// warm up allocator and runtime before taking the baseline
+	sign(1000)
+	before := rss(t)
+	sign(20000)
+	after := rss(t)
+
+	// each leaked signer would hold on to a copy of the private key (~1.7KB)
+	if growth := after - before; growth > 8<<20 {
+		t.Fatalf("rss grew by %d bytes", growth)
+	}
+}
+
+func signAndVerify(hdr map[string]string, body string, t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(hdr, body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vrfy, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	stat = vrfy.Verify(bytes.NewReader(out))
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+}
+
+func TestEmptyInput(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	// must not panic, the returned status is up to the library
+	d.Header("")
+	d.Eoh()
+	d.Body(nil)
+	d.Body([]byte{})
+}
+
+func TestSignAndVerifyEmptyBody(t *testing.T) {
+	signAndVerify(msgHdr, "", t)
+}
+
+func TestSignAndVerifyEmptyHeaderValue(t *testing.T) {
+	var hdr = make(map[string]string)
+	for k, v := range msgHdr {
+		hdr[k] = v
+	}
+	hdr["X-Empty"] = ""
+
+	signAndVerify(hdr, msgBody, t)
+}
+
+// sigTag returns the value of tag in the DKIM-Signature header of msg.
+func sigTag(msg []byte, tag string, t *testing.T) string {
+	m, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := m.Header.Get("DKIM-Signature")
+	if sig == "" {
+		t.Fatal("signature header not found")
+	}
+	for _, kv := range strings.Split(sig, ";") {
+		kv = strings.Join(strings.Fields(kv), "")
+		if strings.HasPrefix(kv, tag+"=") {
+			return kv[len(tag)+1:]
+		}
+	}
+	return ""
+}
+
+func TestSignPreservesHeaderOrder(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := createMsg(msgHdr, msgBody)
+
+	var h []string
+	for i := 0; i < 2; i++ {
+		d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		out, err := d.Sign(bytes.NewReader(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(out, msg[:bytes.Index(msg, []byte("\r\n\r\n"))]) {
+			t.Fatal("header order changed")
+		}
+		h = append(h, sigTag(out, "h", t))
+	}
+	if h[0] == "" || h[0] != h[1] {
+		t.Fatal(h)
+	}
+}
+
+func TestSignSimpleNoSpaceAfterColon(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := []byte("From:a@b.com\r\nSubject:NoSpace\r\nTo:  b@c.com\r\n\r\nbody\r\n")
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonSIMPLE, CanonSIMPLE, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	out, err := d.Sign(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fields must be passed on and written back as they were
+	if !bytes.HasPrefix(out, msg[:bytes.Index(msg, []byte("\r\n\r\n"))+2]) {
+		t.Fatalf("%q", out)
+	}
+	if !strings.Contains(strings.ToLower(sigTag(out, "h", t)), "subject") {
+		t.Fatal("subject not signed")
+	}
+	verify(lib, out, t).Destroy()
+}
+
+func TestSignLineEndings(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	// bare LF line endings and a folded field
+	msg := []byte("From: a@b.com\nSubject: folded\n\tsubject\nTo: b@c.com\n\nbody\r\n")
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	d.SetMargin(40)
+	out, err := d.Sign(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	end := bytes.Index(out, []byte("\r\n\r\n"))
+	if end < 0 || !bytes.Equal(out[end+4:], []byte("body\r\n")) {
+		t.Fatalf("%q", out)
+	}
+	hdr := out[:end+2]
+	if n := bytes.Count(hdr, []byte("\n")); n != bytes.Count(hdr, []byte("\r\n")) || bytes.Contains(hdr, []byte("\r\r")) {
+		t.Fatalf("%q", hdr)
+	}
+
+	m, err := mail.ReadMessage(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Header.Get("DKIM-Signature") == "" || m.Header.Get("Subject") != "folded subject" {
+		t.Fatal(m.Header)
+	}
+	body, _ := io.ReadAll(m.Body)
+	if string(body) != "body\r\n" {
+		t.Fatalf("%q", body)
+	}
+	verify(lib, out, t).Destroy()
+}
+
+func TestSigField(t *testing.T) {
+	for v, want := range map[string]string{
+		"v=1; a=rsa-sha256;\r\n\tb=abc":   "DKIM-Signature: v=1; a=rsa-sha256;\r\n\tb=abc\r\n",
+		"v=1; a=rsa-sha256;\n\tb=abc":     "DKIM-Signature: v=1; a=rsa-sha256;\r\n\tb=abc\r\n",
+		"v=1; a=rsa-sha256;\n\tb=abc\r\n": "DKIM-Signature: v=1; a=rsa-sha256;\r\n\tb=abc\r\n",
+	} {
+		if x := sigField(v); x != want {
+			t.Errorf("%q: %q", v, x)
+		}
+	}
+}
+
+func TestSignAndVerifyRepeated(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		signAndVerify(msgHdr, msgBody, t)
+	}
+}
+
+func TestGetSigHdrLargeKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	var hdr = make(map[string]string)
+	for k, v := range msgHdr {
+		hdr[k] = v
+	}
+	var extra []string
+	for i := 0; i < 40; i++ {
+		name := "X-Extra-Header-" + strconv.Itoa(i)
+		hdr[name] = "value"
+		extra = append(extra, name)
+	}
+
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetOversignHeaders(extra); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	d, stat := lib.NewSigner(string(pemKey), selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	out, err := d.Sign(bytes.NewReader(createMsg(hdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := strings.ToLower(sigTag(out, "h", t))
+	for _, name := range extra {
+		if n := strings.Count(":"+h+":", ":"+strings.ToLower(name)+":"); n != 2 {
+			t.Fatal(name, n, h)
+		}
+	}
+	b, err := base64.StdEncoding.DecodeString(sigTag(out, "b", t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 512 {
+		t.Fatal(len(b))
+	}
+
+	zc, stat := d.SigHdrZeroCopy()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if sig, _ := d.GetSigHdr(); zc != sig || !bytes.Contains(out, []byte(zc)) {
+		t.Fatalf("%q", zc)
+	}
+
+	// the signed message verifies, but not with an oversigned field added
+	rec, err := keyRecord(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	vlib := Init()
+	defer vlib.Close()
+
+	vlib.SetKeyLookup(func(sig *Signature, domain, selector string) ([]byte, Status) {
+		return []byte(rec), StatusOK
+	})
+	verify(vlib, out, t).Destroy()
+
+	v, stat := vlib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer v.Destroy()
+
+	added := append([]byte("X-Extra-Header-0: added\r\n"), out...)
+	if stat = v.Verify(bytes.NewReader(added)); stat == StatusOK {
+		t.Fatal(stat)
+	}
+}
+
+func TestVerifyChunked(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vrfy, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	for len(out) > 0 {
+		n := 7
+		if n > len(out) {
+			n = len(out)
+		}
+		stat = vrfy.Chunk(out[:n])
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		out = out[n:]
+	}
+	stat = vrfy.Chunk(nil)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	stat = vrfy.Eom(nil)
+	if stat != StatusOK {
+		t.Log(vrfy.GetError())
+		t.Fatal(stat)
+	}
+}
+
+func TestVerifyStream(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	var body bytes.Buffer
+	for body.Len() < 4<<20 {
+		body.WriteString("The quick brown fox jumps over the lazy dog.\r\n")
+	}
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, body.String())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vrfy, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	stat = vrfy.VerifyStream(bytes.NewReader(out))
+	if stat != StatusOK {
+		t.Log(vrfy.GetError())
+		t.Fatal(stat)
+	}
+}
+
+func sign(lib *Lib, msg []byte, t testing.TB) []byte {
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	out, err := d.Sign(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func verify(lib *Lib, msg []byte, t *testing.T) *Dkim {
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	stat = d.Verify(bytes.NewReader(msg))
+	if stat != StatusOK {
+		t.Log(d.GetError())
+		t.Fatal(stat)
+	}
+	return d
+}
+
+func TestMilterSequence(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	type field struct{ name, value string }
+	hdrs := []field{
+		{"From", "Chocomoko <a@b.com>"},
+		{"To", "Erik Aigner <b@c.com>"},
+		{"Subject", "Fw: Homepage,\n\tfolded"},
+		{"Date", "Sun, 3 Mar 2013 16:43:40 +0100"},
+	}
+	chunks := []string{"> B=C3=BCro\r\n", "second chunk\r\n"}
+
+	// the signer sees the message as a milter would
+	signer, stat := lib.NewSigner(testKey, selector, domain, CanonSIMPLE, CanonSIMPLE, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer signer.Destroy()
+
+	for _, h := range hdrs {
+		if stat = signer.HeaderField(h.name, h.value); stat != StatusOK {
+			t.Fatal(stat, signer.GetError())
+		}
+	}
+	if stat = signer.Eoh(); stat != StatusOK {
+		t.Fatal(stat, signer.GetError())
+	}
+	for _, c := range chunks {
+		if stat = signer.Body([]byte(c)); stat != StatusOK {
+			t.Fatal(stat, signer.GetError())
+		}
+	}
+	if stat = signer.Eom(nil); stat != StatusOK {
+		t.Fatal(stat, signer.GetError())
+	}
+	sig, stat := signer.GetSigHdr()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	// the verifier gets it from the wire
+	var msg bytes.Buffer
+	msg.WriteString(sigHdrPrefix + sig + "\r\n")
+	for _, h := range hdrs {
+		msg.WriteString(h.name + ": " + strings.ReplaceAll(h.value, "\n", "\r\n") + "\r\n")
+	}
+	msg.WriteString("\r\n" + strings.Join(chunks, ""))
+	verify(lib, msg.Bytes(), t).Destroy()
+
+	// a failing step stops the sequence
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if stat = d.HeaderField("From", "a@b.com"); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if stat = d.HeaderField("DKIM-Signature", "v=1; a=bogus"); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if stat = d.Eoh(); stat == StatusOK {
+		t.Fatal(stat)
+	}
+}
+
+func TestSignatureIdentifiers(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	sig := d.GetSignature()
+	if sig == nil {
+		t.Fatal()
+	}
+	if x := sig.Domain(); x != domain {
+		t.Fatal(x)
+	}
+	if x := sig.Selector(); x != selector {
+		t.Fatal(x)
+	}
+	if x := sig.Identity(); !strings.HasSuffix(x, "@"+domain) {
+		t.Fatal(x)
+	}
+}
+
+func TestSignaturePredicates(t *testing.T) {
+	for _, tc := range []struct {
+		flags       string
+		testKey     bool
+		noSubdomain bool
+	}{
+		{"", false, false},
+		{"; t=y", true, false},
+		{"; t=s", false, true},
+		{"; t=y:s", true, true},
+	} {
+		lib := Init()
+		rec := testRecord(t) + tc.flags
+		lib.SetKeyLookup(func(sig *Signature, domain, selector string) ([]byte, Status) {
+			return []byte(rec), StatusOK
+		})
+
+		d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+		sig := d.GetSignature()
+		if sig == nil {
+			t.Fatal(tc.flags)
+		}
+		if !sig.Processed() || !sig.Passed() || !sig.KeyLoaded() {
+			t.Errorf("%q: %v", tc.flags, sig.Flags())
+		}
+		if sig.IsTestKey() != tc.testKey || sig.NoSubdomain() != tc.noSubdomain {
+			t.Errorf("%q: %v", tc.flags, sig.Flags())
+		}
+		d.Destroy()
+		lib.Close()
+	}
+}
+
+func TestEomResult(t *testing.T) {
+	for _, flags := range []string{"", "; t=y"} {
+		lib := Init()
+		rec := testRecord(t) + flags
+		lib.SetKeyLookup(func(sig *Signature, domain, selector string) ([]byte, Status) {
+			return []byte(rec), StatusOK
+		})
+		msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		if _, stat = d.processHeader(bufio.NewReader(bytes.NewReader(msg))); stat != StatusOK {
+			t.Fatal(stat)
+		}
+		if stat = d.Body(msg[headerEnd(msg):]); stat != StatusOK {
+			t.Fatal(stat)
+		}
+		testKey, stat := d.EomResult()
+		if stat != StatusOK || testKey != (flags != "") {
+			t.Errorf("%q: %v %v", flags, testKey, stat)
+		}
+		d.Destroy()
+
+		if _, stat = d.EomResult(); stat != StatusINVALID {
+			t.Fatal(stat)
+		}
+		lib.Close()
+	}
+}
+
+func TestSignatureReputation(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	sig := d.GetSignature()
+	if !hasReputation() {
+		if _, stat := sig.Reputation(); stat != StatusNOTIMPLEMENT {
+			t.Fatal(stat)
+		}
+		t.Skip("reputation queries not supported by libopendkim")
+	}
+	if _, stat := sig.Reputation(); stat == StatusNOTIMPLEMENT {
+		t.Fatal(stat)
+	}
+}
+
+func TestSignatureTimes(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	signed := time.Unix(1362325420, 0)
+	lib.SetFixedTime(signed)
+	lib.SetSignatureTTL(time.Hour)
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+	if x := sigTag(msg, "x", t); x != "1362329020" {
+		t.Fatal(x)
+	}
+	// the signature has expired by now, but its tags can still be read
+	sig := verifyAny(lib, msg, t)
+
+	if ts, ok := sig.Timestamp(); !ok || !ts.Equal(signed) {
+		t.Fatal(ts, ok)
+	}
+	if x, ok := sig.Expiry(); !ok || !x.Equal(signed.Add(time.Hour)) {
+		t.Fatal(x, ok)
+	}
+	if sig.Expired(signed) || !sig.Expired(signed.Add(2*time.Hour)) {
+		t.Fatal()
+	}
+
+	// a signature without t= and x=
+	msg = bytes.Replace(msg, []byte("t=1362325420;"), nil, 1)
+	msg = bytes.Replace(msg, []byte("x=1362329020;"), nil, 1)
+	sig = verifyAny(lib, msg, t)
+
+	if ts, ok := sig.Timestamp(); ok {
+		t.Fatal(ts)
+	}
+	if x, ok := sig.Expiry(); ok {
+		t.Fatal(x)
+	}
+	if sig.Expired(time.Now()) {
+		t.Fatal()
+	}
+}
+
+// verifyAny verifies msg and returns its signature, whether it passed or
+// not.
+func verifyAny(lib *Lib, msg []byte, t *testing.T) *Signature {
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	t.Cleanup(func() { d.Destroy() })
+
+	d.Verify(bytes.NewReader(msg))
+	sig := d.GetSignature()
+	if sig == nil {
+		t.Fatal("no signature")
+	}
+	return sig
+}
+
+func TestBodyHashMismatch(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+	out = append(out, "tampered\r\n"...)
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	stat = d.Verify(bytes.NewReader(out))
+	if stat != StatusBADSIG {
+		t.Fatal(stat)
+	}
+	sig := d.GetSignature()
+	if sig == nil {
+		t.Fatal()
+	}
+	if x := sig.BodyHashResult(); x != BodyHashMISMATCH {
+		t.Fatal(x)
+	}
+}
+
+func TestSignatureCanonicalizations(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	sig := d.GetSignature()
+	if sig == nil {
+		t.Fatal()
+	}
+	hdr, body := sig.Canonicalizations()
+	if hdr != CanonRELAXED {
+		t.Fatal(hdr)
+	}
+	if body != CanonRELAXED {
+		t.Fatal(body)
+	}
+}
+
+func TestSignatureAlgorithmAndKeySize(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	sig := d.GetSignature()
+	if sig == nil {
+		t.Fatal()
+	}
+	if x := sig.Algorithm(); x != SignRSASHA256 {
+		t.Fatal(x)
+	}
+	bits, stat := sig.KeySize()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if bits != 2048 {
+		t.Fatal(bits)
+	}
+}
+
+func TestGetSignatures(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	d, stat := lib.NewSigner(testKey, selector, "example.com", CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out, err := d.Sign(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vrfy, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer vrfy.Destroy()
+
+	// the second signature can't be verified, only the list matters here
+	vrfy.Verify(bytes.NewReader(out))
+
+	sigs, stat := vrfy.GetSignatures()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if len(sigs) != 2 {
+		t.Fatal(len(sigs))
+	}
+	domains := map[string]bool{}
+	for _, sig := range sigs {
+		domains[sig.Domain()] = true
+	}
+	if !domains[domain] || !domains["example.com"] {
+		t.Fatal(domains)
+	}
+}
+
+func TestSignatureErrNoKey(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, "nonexistent", domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vrfy, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer vrfy.Destroy()
+
+	if stat = vrfy.Verify(bytes.NewReader(out)); stat == StatusOK {
+		t.Fatal(stat)
+	}
+	sig := vrfy.GetSignature()
+	if sig == nil {
+		t.Fatal()
+	}
+	if x := sig.Err(); x != SigErrorNOKEY {
+		t.Fatal(x)
+	}
+	if x := sig.ErrString(); x != "no key" {
+		t.Fatal(x)
+	}
+}
+
+func TestSignatureDNSSEC(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	sig := d.GetSignature()
+	if sig == nil {
+		t.Fatal()
+	}
+	switch x := sig.DNSSEC(); x {
+	case DNSSECUnknown, DNSSECBogus, DNSSECInsecure, DNSSECSecure:
+	default:
+		t.Fatal(x)
+	}
+}
+
+func TestSignatureHeaderSigned(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	sig := d.GetSignature()
+	if sig == nil {
+		t.Fatal()
+	}
+	if !sig.HeaderSigned("From") {
+		t.Fatal("From not signed")
+	}
+	if sig.HeaderSigned("X-Mailer") {
+		t.Fatal("X-Mailer signed")
+	}
+}
+
+func TestSignatureTagValue(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+	d := verify(lib, out, t)
+	defer d.Destroy()
+
+	sig := d.GetSignature()
+	if sig == nil {
+		t.Fatal()
+	}
+	if v, ok := sig.TagValue("v"); !ok || v != "1" {
+		t.Fatal(v)
+	}
+	if v, ok := sig.TagValue("a"); !ok || v != "rsa-sha256" {
+		t.Fatal(v)
+	}
+	if v, ok := sig.TagValue("bh"); !ok || v != sigTag(out, "bh", t) {
+		t.Fatal(v)
+	}
+	if v, ok := sig.TagValue("zz"); ok {
+		t.Fatal(v)
+	}
+}
+
+func TestEomContextCancelled(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	br := bufio.NewReader(bytes.NewReader(out))
+	if _, stat = d.processHeader(br); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	body, _ := io.ReadAll(br)
+	if stat = d.Body(body); stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if stat = d.EomContext(ctx, nil); stat != StatusCBTRYAGAIN {
+		t.Fatal(stat)
+	}
+	if x := time.Since(start); x > time.Second {
+		t.Fatal(x)
+	}
+}
+
+func TestNewSignerFromKeyFile(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, err := lib.NewSignerFromKeyFile("testdata/odktest.key", selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(lib, out, t).Destroy()
+
+	_, err = lib.NewSignerFromKeyFile("testdata/missing.key", selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	_, err = lib.NewSignerFromKeyFile("testdata/keys", selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if err == nil {
+		t.Fatal("expected error for non-PEM file")
+	}
+}
+
+func TestNewSignerBytes(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	block, _ := pem.Decode([]byte(testKey))
+	if bytes.IndexByte(block.Bytes, 0) < 0 {
+		t.Fatal("expected DER key to contain NUL bytes")
+	}
+
+	for _, key := range [][]byte{[]byte(testKey), block.Bytes} {
+		d, stat := lib.NewSignerBytes(key, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		verify(lib, out, t).Destroy()
+	}
+
+	_, stat := lib.NewSignerBytes([]byte(testKey[:100]+"\x00"+testKey[100:]), selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusINVALID {
+		t.Fatal(stat)
+	}
+}
+
+func TestSignAndVerifyEd25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := filepath.Join(t.TempDir(), "keys")
+	record := "ed25519._domainkey." + domain + " v=DKIM1; k=ed25519; p=" + base64.StdEncoding.EncodeToString(pub) + "\n"
+	if err := os.WriteFile(keys, []byte(record), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lib := Init()
+	defer lib.Close()
+
+	if stat := lib.SetQueryMethodFile(keys); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	d, stat := lib.NewSignerBytes(der, "ed25519", domain, CanonRELAXED, CanonRELAXED, SignED25519SHA256, -1)
+	if stat != StatusOK {
+		t.Skip("ed25519 not supported by libopendkim:", stat)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := sigTag(out, "a", t); x != "ed25519-sha256" {
+		t.Fatal(x)
+	}
+
+	vrfy := verify(lib, out, t)
+	defer vrfy.Destroy()
+
+	if x := vrfy.GetSignature().Algorithm(); x != SignED25519SHA256 {
+		t.Fatal(x)
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if _, stat = d.Identity(); stat != StatusNOSIG {
+		t.Fatal(stat)
+	}
+	if stat = d.Verify(bytes.NewReader(sign(lib, createMsg(msgHdr, msgBody), t))); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	id, stat := d.Identity()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if id != "@"+domain {
+		t.Fatal(id)
+	}
+}
+
+func TestDomainAndUser(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	if x := d.Domain(); x != "b.com" {
+		t.Fatal(x)
+	}
+	if x := d.User(); x != "a" {
+		t.Fatal(x)
+	}
+
+	d.Destroy()
+	if d.Domain() != "" || d.User() != "" {
+		t.Fatal()
+	}
+}
+
+func TestSetPartial(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if stat = d.SetPartial(true); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if !d.Partial() {
+		t.Fatal()
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strconv.Itoa(len(canonBody([]byte(msgBody), CanonRELAXED)))
+	if x := sigTag(out, "l", t); x != want {
+		t.Fatal(x, want)
+	}
+	verify(lib, append(out, "appended footer\r\n"...), t).Destroy()
+
+	// other signers of lib are unaffected
+	if x := sigTag(sign(lib, createMsg(msgHdr, msgBody), t), "l", t); x != "" {
+		t.Fatal(x)
+	}
+}
+
+func TestSetSigner(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if x := d.Signer(); x != "" {
+		t.Fatal(x)
+	}
+	signer := "list@lists." + domain
+	if stat = d.SetSigner(signer); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if x := d.Signer(); x != signer {
+		t.Fatal(x)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := sigTag(out, "i", t); x != signer {
+		t.Fatal(x)
+	}
+
+	vrfy := verify(lib, out, t)
+	defer vrfy.Destroy()
+
+	if id, _ := vrfy.Identity(); id != signer {
+		t.Fatal(id)
+	}
+}
+
+func TestGetSigHdrMargin(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	hdr, body, stat := d.process(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	firstLine := func(s string) string {
+		if i := strings.Index(s, "\n"); i >= 0 {
+			return s[:i]
+		}
+		return s
+	}
+	a, stat := d.GetSigHdrMargin(0)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	b, stat := d.GetSigHdrMargin(40)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if firstLine(a) == firstLine(b) {
+		t.Fatal("fold points don't differ")
+	}
+
+	for _, h := range []string{a, b} {
+		msg := hdr.String() + "DKIM-Signature: " + h + "\r\n\r\n" + body.String()
+		verify(lib, []byte(msg), t).Destroy()
+	}
+}
+
+func TestSetMargin(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	const margin = 40
+	if stat = d.SetMargin(margin); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := out[bytes.Index(out, []byte(sigHdrPrefix)):]
+	sig = sig[:bytes.Index(sig, []byte("\r\n\r\n"))]
+	lines := strings.Split(string(sig), "\r\n")
+	if len(lines) < 2 {
+		t.Fatal(lines)
+	}
+	for _, l := range lines {
+		if n := len(strings.ReplaceAll(l, "\t", "        ")); n > margin {
+			t.Errorf("%d columns: %q", n, l)
+		}
+	}
+	verify(lib, out, t).Destroy()
+}
+
+func TestDestroyWhileProcessing(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+	for i := 0; i < 100; i++ {
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			d.Verify(bytes.NewReader(msg))
+			d.GetSigHdr()
+			d.GetError()
+		}()
+		go func() {
+			defer wg.Done()
+			d.Destroy()
+		}()
+		wg.Wait()
+
+		if stat := d.Eoh(); stat != StatusINVALID {
+			t.Fatal(stat)
+		}
+	}
+}
+
+func TestSignatureAfterDestroy(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+	for i := 0; i < 100; i++ {
+		d := verify(lib, msg, t)
+		sig := d.GetSignature()
+		if sig == nil {
+			t.Fatal()
+		}
+
+		// run with -race to check accessors and Destroy don't overlap
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sig.Domain()
+			sig.Flags()
+			sig.TagValue("bh")
+			sig.KeySize()
+		}()
+		go func() {
+			defer wg.Done()
+			d.Destroy()
+		}()
+		wg.Wait()
+
+		if x := sig.Domain(); x != "" {
+			t.Fatal(x)
+		}
+		if x := sig.Flags(); x != 0 {
+			t.Fatal(x)
+		}
+		if _, stat := sig.KeySize(); stat != StatusINVALID {
+			t.Fatal(stat)
+		}
+		if _, stat := sig.SignedBodyLen(); stat != StatusINVALID {
+			t.Fatal(stat)
+		}
+		if stat := sig.Process(); stat != StatusINVALID {
+			t.Fatal(stat)
+		}
+		if _, ok := sig.TagValue("bh"); ok {
+			t.Fatal()
+		}
+	}
+}
+
+func TestOriginalHeaders(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	flags := uint32(LibflagsZTAGS)
+	lib.Options(SetOpt, OptionFLAGS, unsafe.Pointer(&flags), unsafe.Sizeof(flags))
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	hdrs, stat := d.OriginalHeaders()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	var found bool
+	for _, h := range hdrs {
+		if strings.HasPrefix(strings.ToLower(h), "subject:") {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatal(hdrs)
+	}
+}
+
+func TestDiffHeaders(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	flags := uint32(LibflagsZTAGS)
+	lib.Options(SetOpt, OptionFLAGS, unsafe.Pointer(&flags), unsafe.Sizeof(flags))
+
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+	out = bytes.Replace(out, []byte("Subject: Fw: Homepage"), []byte("Subject: Fw: Homepages"), 1)
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if stat = d.Verify(bytes.NewReader(out)); stat != StatusBADSIG {
+		t.Fatal(stat)
+	}
+	diffs, stat := d.DiffHeaders(10)
+	if stat == StatusNOTIMPLEMENT {
+		t.Skip("libopendkim built without diffheaders support")
+	}
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if len(diffs) != 1 {
+		t.Fatal(diffs)
+	}
+	if !strings.Contains(diffs[0].New, "Homepages") || strings.Contains(diffs[0].Old, "Homepages") {
+		t.Fatal(diffs[0])
+	}
+}
+
+func TestSignatureATPSCheck(t *testing.T) {
+	signer := testLib(t)
+	defer signer.Close()
+
+	d, stat := signer.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	// ask for authorization by the From domain, with the signing
+	// domain queried unhashed
+	if stat = d.SetSignatureTagValues(map[string]string{"atps": "b.com", "atpsh": "none"}); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	msg, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := selector + "._domainkey." + domain
+	for _, tc := range []struct {
+		name    string
+		records map[string]string
+		want    ATPSResult
+	}{
+		{"published", map[string]string{key: testRecord(t), domain + "._atps.b.com": "v=ATPS1;"}, ATPSFound},
+		{"missing", map[string]string{key: testRecord(t)}, ATPSNotFound},
+	} {
+		// ATPS records are always queried through DNS, not the key file
+		lib := Init()
+		lib.SetResolver(newMapResolver(tc.records))
+
+		v := verify(lib, msg, t)
+		res, stat := v.GetSignature().ATPSCheck()
+		v.Destroy()
+		lib.Close()
+
+		if stat == StatusNOTIMPLEMENT {
+			t.Skip("libopendkim built without ATPS support")
+		}
+		if stat != StatusOK || res != tc.want {
+			t.Errorf("%s: %v %v", tc.name, res, stat)
+		}
+	}
+}
+
+func TestMinBody(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	flags := uint32(LibflagsSIGNLEN)
+	lib.Options(SetOpt, OptionFLAGS, unsafe.Pointer(&flags), unsafe.Sizeof(flags))
+
+	body := msgBody + strings.Repeat("more body data\r\n", 100)
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonSIMPLE, CanonSIMPLE, SignRSASHA256, 10)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := sigTag(out, "l", t); x != "10" {
+		t.Fatal(x)
+	}
+
+	vrfy, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer vrfy.Destroy()
+
+	br := bufio.NewReader(bytes.NewReader(out))
+	if _, stat = vrfy.processHeader(br); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	chunk := make([]byte, 4)
+	var fed int
+	for vrfy.MinBody() > 0 {
+		n, err := br.Read(chunk)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat = vrfy.Body(chunk[:n]); stat != StatusOK {
+			t.Fatal(stat)
+		}
+		fed += n
+	}
+	if fed >= len(body) {
+		t.Fatal("whole body was needed")
+	}
+	if stat = vrfy.Eom(nil); stat != StatusOK {
+		t.Fatal(stat)
+	}
+}
+
+func TestResign(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	vrfy, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer vrfy.Destroy()
+
+	d, stat := lib.Resign(vrfy, testKey, selector, "example.org", CanonRELAXED, CanonRELAXED, SignRSASHA256, true)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if stat = vrfy.Verify(bytes.NewReader(msg)); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if stat = d.Eom(nil); stat != StatusOK {
+		t.Log(d.GetError())
+		t.Fatal(stat)
+	}
+	h, stat := d.GetSigHdr()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	out := append([]byte("DKIM-Signature: "+h+"\r\n"), msg...)
+	v2 := verify(lib, out, t)
+	defer v2.Destroy()
+
+	sigs, stat := v2.GetSignatures()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if len(sigs) != 2 {
+		t.Fatal(len(sigs))
+	}
+	for _, sig := range sigs {
+		if stat = sig.Process(); stat != StatusOK {
+			t.Fatal(sig.Domain(), stat)
+		}
+		if sig.Flags()&SigflagPASSED == 0 {
+			t.Fatal(sig.Domain(), sig.Flags())
+		}
+	}
+}
+
+func TestHasFeature(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	for _, f := range []Feature{FeatureSHA256, FeatureDNSSEC, FeatureATPS, FeatureCONDITIONAL, FeatureED25519} {
+		t.Logf("feature %d: %v", f, lib.HasFeature(f))
+	}
+	if lib.HasFeature(featureUndefined) {
+		t.Fatal("undefined feature reported")
+	}
+}
+
+func TestVersion(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	if lib.SSLVersion() == 0 {
+		t.Fatal()
+	}
+	t.Log(Version())
+}
+
+func TestCacheStats(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if !lib.HasFeature(FeatureQUERYCACHE) {
+		t.Skip("libopendkim built without query cache")
+	}
+	flags := uint32(LibflagsCACHE)
+	lib.Options(SetOpt, OptionFLAGS, unsafe.Pointer(&flags), unsafe.Sizeof(flags))
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+	verify(lib, msg, t).Destroy()
+	before, stat := lib.CacheStats()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	verify(lib, msg, t).Destroy()
+	after, stat := lib.CacheStats()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if after.Hits <= before.Hits {
+		t.Fatal(before, after)
+	}
+	if n := lib.FlushCache(); n < 0 {
+		t.Fatal(n)
+	}
+}
+
+func TestMessageDate(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	want, err := time.Parse("Mon, 2 Jan 2006 15:04:05 -0700", msgHdr["Date"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	date, ok := d.MessageDate()
+	if !ok || !date.Equal(want) {
+		t.Fatal(date, ok)
+	}
+
+	hdr := map[string]string{"From": msgHdr["From"]}
+	d = verify(lib, sign(lib, createMsg(hdr, msgBody), t), t)
+	defer d.Destroy()
+
+	if date, ok := d.MessageDate(); ok {
+		t.Fatal(date)
+	}
+}
+
+func TestSignedBodyLen(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	sig := d.GetSignature()
+	n, stat := sig.SignedBodyLen()
+	if stat != StatusOK || n != int64(len(msgBody)) {
+		t.Fatal(n, stat)
+	}
+	if c, ok := sig.CanonicalizedBodyLen(); !ok || c != n {
+		t.Fatal(c, ok)
+	}
+
+	flags := uint32(LibflagsSIGNLEN)
+	lib.Options(SetOpt, OptionFLAGS, unsafe.Pointer(&flags), unsafe.Sizeof(flags))
+
+	signer, stat := lib.NewSigner(testKey, selector, domain, CanonSIMPLE, CanonSIMPLE, SignRSASHA256, 5)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer signer.Destroy()
+
+	out, err := signer.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d = verify(lib, out, t)
+	defer d.Destroy()
+
+	sig = d.GetSignature()
+	if n, stat := sig.SignedBodyLen(); stat != StatusOK || n != 5 {
+		t.Fatal(n, stat)
+	}
+	if c, ok := sig.CanonicalizedBodyLen(); !ok || c != 5 {
+		t.Fatal(c, ok)
+	}
+	if x := sigTag(out, "l", t); x != "5" {
+		t.Fatal(x)
+	}
+}
+
+func TestSignatureReportInfo(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	lib.SetResolver(newMapResolver(map[string]string{
+		selector + "._domainkey." + domain: testRecord(t) + "; r=y",
+		"_report._domainkey." + domain:     "ra=dkim-reports; rp=50; rr=all; rs=signature verification failed",
+	}))
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	info, stat := d.GetSignature().ReportInfo()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if !strings.HasPrefix(info.Address, "dkim-reports") {
+		t.Fatal(info.Address)
+	}
+	if info.Options != "all" || info.SMTP != "signature verification failed" || info.Percent != 50 {
+		t.Fatal(info)
+	}
+}
+
+func TestSignPreservesFolding(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	hdr := "Received: from mail.example.org (mail.example.org [192.0.2.1])\r\n" +
+		"\tby mx.erikk.org with ESMTP id 1234;\r\n" +
+		"        Sun, 3 Mar 2013 16:43:40 +0100\r\n" +
+		"From: Chocomoko <a@b.com>\r\n" +
+		"Subject:  a deliberately  \r\n" +
+		"   folded subject\r\n" +
+		"\r\n"
+	msg := []byte(hdr + msgBody)
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonSIMPLE, CanonSIMPLE, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	out, err := d.Sign(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(out, []byte(hdr[:len(hdr)-2])) {
+		t.Fatalf("%q", out)
+	}
+	verify(lib, out, t).Destroy()
+}
+
+func TestStatusPredicates(t *testing.T) {
+	for _, tc := range []struct {
+		s              Status
+		ok, temp, perm bool
+	}{
+		{StatusOK, true, false, false},
+		{StatusNORESOURCE, false, true, false},
+		{StatusKEYFAIL, false, true, false},
+		{StatusCBTRYAGAIN, false, true, false},
+		{StatusMULTIDNSREPLY, false, true, false},
+		{StatusBADSIG, false, false, true},
+		{StatusNOSIG, false, false, true},
+		{StatusNOKEY, false, false, true},
+		{StatusREVOKED, false, false, true},
+		{StatusSYNTAX, false, false, true},
+		{StatusINTERNAL, false, false, false},
+	} {
+		if tc.s.IsOK() != tc.ok || tc.s.IsTempFail() != tc.temp || tc.s.IsPermFail() != tc.perm {
+			t.Errorf("%d: %v %v %v", int(tc.s), tc.s.IsOK(), tc.s.IsTempFail(), tc.s.IsPermFail())
+		}
+	}
+
+	var err error = fmt.Errorf("verify: %w", StatusNOKEY)
+	if !errors.Is(err, StatusNOKEY) || errors.Is(err, StatusBADSIG) {
+		t.Fatal(err)
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	var err error = &StatusError{Status: StatusNOKEY, Detail: "no key for selector foo"}
+	if err.Error() != "NOKEY: no key for selector foo" {
+		t.Fatal(err)
+	}
+	if !errors.Is(err, StatusNOKEY) || errors.Is(err, StatusBADSIG) {
+		t.Fatal(err)
+	}
+
+	lib := Init()
+	defer lib.Close()
+
+	path := filepath.Join(t.TempDir(), "bad.key")
+	bad := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte("not a key")})
+	if err := os.WriteFile(path, bad, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = lib.NewSignerFromKeyFile(path, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	var se *StatusError
+	if !errors.As(err, &se) {
+		t.Fatal(err)
+	}
+	if se.Detail == "" || !strings.HasPrefix(err.Error(), se.Status.name()+": ") || !errors.Is(err, se.Status) {
+		t.Fatal(err)
+	}
+}
+
+func TestSetSignatureTagValues(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if stat := d.SetSignatureTagValues(map[string]string{"b": "forged"}); stat != StatusINVALID {
+		t.Fatal(stat)
+	}
+	if stat := d.SetSignatureTagValues(map[string]string{"xfoo": "bar"}); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := sigTag(out, "xfoo", t); x != "bar" {
+		t.Fatal(x)
+	}
+	verify(lib, out, t).Destroy()
+}
+
+func TestSignBodyLength(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	body := msgBody + strings.Repeat("more body data\r\n", 10)
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonSIMPLE, CanonSIMPLE, SignRSASHA256, 10)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x := sigTag(out, "l", t); x != "10" {
+		t.Fatal(x)
+	}
+	verify(lib, out, t).Destroy()
+	verify(lib, append(out, "appended\r\n"...), t).Destroy()
+
+	// the limit must not leak into later full body signatures
+	if lib.Flags()&LibflagsSIGNLEN != 0 {
+		t.Fatal(lib.Flags())
+	}
+	if x := sigTag(sign(lib, createMsg(msgHdr, body), t), "l", t); x != "" {
+		t.Fatal(x)
+	}
+}
@@ -0,0 +1,45 @@
+package opendkim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func FuzzVerify(f *testing.F) {
+	lib := Init()
+	f.Cleanup(func() { lib.Close() })
+	if stat := lib.SetQueryMethodFile("testdata/keys"); stat != StatusOK {
+		f.Fatal(stat)
+	}
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		f.Fatal(stat)
+	}
+	msg, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	d.Destroy()
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	f.Add(msg)
+	f.Add(msg[:len(msg)/2])                                          // truncated header
+	f.Add(bytes.ReplaceAll(msg, []byte("\r\n\r\n"), []byte("\r\n"))) // no blank line
+	f.Add(append(createMsg(msgHdr, ""), 0, 0xff, '\r', 0, '\n'))     // binary body
+	f.Add([]byte(strings.Repeat("X-Header: x\r\n", 10000) + "\r\n")) // absurd header count
+	f.Add([]byte{})
+	f.Add([]byte("\r\n"))
+	f.Add([]byte("DKIM-Signature: \r\n\r\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		defer d.Destroy()
+
+		d.process(bytes.NewReader(data))
+		d.GetSignatures()
+	})
+}
@@ -0,0 +1,118 @@
+package opendkim
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+)
+
+// maxTXTSegment is the maximum length of a single TXT character string.
+const maxTXTSegment = 255
+
+var errUnsupportedKey = errors.New("unsupported private key type")
+
+// PublicKeyTXT returns the DKIM key record for the public half of an RSA
+// or Ed25519 private key in PEM format, ready to be published in a
+// selector's TXT record. The record is split into quoted strings of at
+// most 255 bytes, as required for DNS.
+func PublicKeyTXT(privatePEM []byte) (string, error) {
+	key, err := parsePrivateKey(privatePEM)
+	if err != nil {
+		return "", err
+	}
+	rec, err := keyRecord(key.Public())
+	if err != nil {
+		return "", err
+	}
+	return quoteTXT(rec), nil
+}
+
+// GenerateKey generates an RSA key of the given size. It returns the
+// private key in PEM format, as accepted by NewSigner, and the TXT record
+// to publish for it; see PublicKeyTXT.
+func GenerateKey(bits int) (privPEM []byte, txt string, err error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, "", err
+	}
+	rec, err := keyRecord(key.Public())
+	if err != nil {
+		return nil, "", err
+	}
+	der := x509.MarshalPKCS1PrivateKey(key)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), quoteTXT(rec), nil
+}
+
+// GenerateKeyEd25519 is like GenerateKey, but generates an Ed25519 key.
+// Signing with it requires libopendkim 2.11.
+func GenerateKeyEd25519() (privPEM []byte, txt string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	rec, err := keyRecord(pub)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, "", err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), quoteTXT(rec), nil
+}
+
+func parsePrivateKey(privatePEM []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(privatePEM)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errUnsupportedKey
+	}
+	return signer, nil
+}
+
+// keyRecord formats the unquoted key record for pub. RSA keys are
+// published as SubjectPublicKeyInfo, Ed25519 keys as the raw key
+// (RFC 8463).
+func keyRecord(pub crypto.PublicKey) (string, error) {
+	var k string
+	var p []byte
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return "", err
+		}
+		k, p = "rsa", der
+	case ed25519.PublicKey:
+		k, p = "ed25519", pub
+	default:
+		return "", errUnsupportedKey
+	}
+	return "v=DKIM1; k=" + k + "; p=" + base64.StdEncoding.EncodeToString(p), nil
+}
+
+func quoteTXT(rec string) string {
+	var segs []string
+	for len(rec) > maxTXTSegment {
+		segs = append(segs, `"`+rec[:maxTXTSegment]+`"`)
+		rec = rec[maxTXTSegment:]
+	}
+	segs = append(segs, `"`+rec+`"`)
+	return strings.Join(segs, " ")
+}
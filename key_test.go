@@ -0,0 +1,88 @@
+package opendkim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// unquoteTXT joins the quoted strings of a TXT record.
+func unquoteTXT(txt string) string {
+	return strings.Trim(strings.Join(strings.Split(txt, `" "`), ""), `"`)
+}
+
+func TestPublicKeyTXT(t *testing.T) {
+	txt, err := PublicKeyTXT([]byte(testKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	segs := strings.Split(txt, `" "`)
+	if len(segs) != 2 || len(segs[0]) != maxTXTSegment+1 {
+		t.Fatal(txt)
+	}
+	if rec, want := unquoteTXT(txt), testRecord(t); rec != want {
+		t.Fatal(rec, want)
+	}
+
+	if _, err := PublicKeyTXT([]byte("not a key")); err == nil {
+		t.Fatal()
+	}
+}
+
+func signAndVerifyKey(priv []byte, txt string, algo Sign, t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	lib.SetKeyLookup(func(sig *Signature, domain, selector string) ([]byte, Status) {
+		return []byte(unquoteTXT(txt)), StatusOK
+	})
+
+	d, stat := lib.NewSigner(string(priv), selector, domain, CanonRELAXED, CanonRELAXED, algo, -1)
+	if stat != StatusOK {
+		if algo == SignED25519SHA256 {
+			t.Skip("ed25519 not supported by libopendkim:", stat)
+		}
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(lib, out, t).Destroy()
+}
+
+func TestGenerateKey(t *testing.T) {
+	priv, txt, err := GenerateKey(2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(txt, `"v=DKIM1; k=rsa; p=`) {
+		t.Fatal(txt)
+	}
+	signAndVerifyKey(priv, txt, SignRSASHA256, t)
+}
+
+func TestGenerateKeyEd25519(t *testing.T) {
+	priv, txt, err := GenerateKeyEd25519()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(txt, `"v=DKIM1; k=ed25519; p=`) {
+		t.Fatal(txt)
+	}
+	signAndVerifyKey(priv, txt, SignED25519SHA256, t)
+}
+
+func TestValidateKeyRecord(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	if stat := lib.ValidateKeyRecord(domain, testRecord(t)); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if stat := lib.ValidateKeyRecord(domain, "v=DKIM1; k=rsa; p"); stat != StatusSYNTAX {
+		t.Fatal(stat)
+	}
+}
@@ -0,0 +1,35 @@
+// +build !windows
+
+package opendkim
+
+/*
+#include <stdlib.h>
+#include <sys/types.h>
+#include <dkim.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+var errMalformedAddress = errors.New("malformed address")
+
+// MailParse extracts the local part and domain of the address in a
+// header value like From, using the same parser libopendkim applies to
+// the From header. Comments and display names are dropped and quoted
+// local parts are unquoted.
+func MailParse(header string) (user, domain string, err error) {
+	addr := C.CString(header)
+	defer C.free(unsafe.Pointer(addr))
+
+	var u, d *C.uchar
+	if C.dkim_mail_parse((*C.uchar)(unsafe.Pointer(addr)), &u, &d) != 0 {
+		return "", "", errMalformedAddress
+	}
+	if d == nil {
+		return goString(u), "", errMalformedAddress
+	}
+	return goString(u), goString(d), nil
+}
@@ -0,0 +1,30 @@
+package opendkim
+
+import "testing"
+
+func TestMailParse(t *testing.T) {
+	for _, tc := range []struct {
+		header, user, domain string
+	}{
+		{"a@b.com", "a", "b.com"},
+		{"Chocomoko <a@b.com>", "a", "b.com"},
+		{"a@b.com (a comment)", "a", "b.com"},
+		{"(comment) Chocomoko <a@b.com> (another)", "a", "b.com"},
+		{`"Aigner, Erik" <b@c.com>`, "b", "c.com"},
+		{`"quoted local"@b.com`, "quoted local", "b.com"},
+		{"Friends: Chocomoko <a@b.com>;", "a", "b.com"},
+	} {
+		user, domain, err := MailParse(tc.header)
+		if err != nil {
+			t.Errorf("%q: %v", tc.header, err)
+			continue
+		}
+		if user != tc.user || domain != tc.domain {
+			t.Errorf("%q: %q %q", tc.header, user, domain)
+		}
+	}
+
+	if _, _, err := MailParse("no address here"); err == nil {
+		t.Fatal()
+	}
+}
@@ -0,0 +1,88 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"bytes"
+	"io"
+	"net/mail"
+	"sort"
+	"strings"
+)
+
+// sigHdrKey is the key of the signature header in a mail.Header.
+const sigHdrKey = "Dkim-Signature"
+
+// SignMessage signs a parsed message and returns a copy with the
+// signature added to its header. m's body is consumed.
+//
+// A mail.Header doesn't record the order of its fields, so the message is
+// signed with its fields in lexical order of their keys, and multiple
+// fields with the same key in the order of their values. Signing only
+// covers what is preserved this way, so the message should be sent with
+// the signature first, followed by the fields in that order; see
+// WriteMessage. Use relaxed header canonicalization, as folding isn't
+// preserved either.
+func (d *Dkim) SignMessage(m *mail.Message) (*mail.Message, error) {
+	body, err := io.ReadAll(m.Body)
+	if err != nil {
+		return nil, err
+	}
+	var raw bytes.Buffer
+	writeHeader(&raw, m.Header)
+	raw.WriteString("\r\n")
+	raw.Write(body)
+
+	if _, _, stat := d.process(&raw); stat != StatusOK {
+		return nil, d.statusError(stat)
+	}
+	sigHdr, stat := d.GetSigHdr()
+	if stat != StatusOK {
+		return nil, d.statusError(stat)
+	}
+
+	hdr := make(mail.Header, len(m.Header)+1)
+	for k, v := range m.Header {
+		hdr[k] = append([]string(nil), v...)
+	}
+	sigHdr = strings.NewReplacer("\r", "", "\n", "").Replace(sigHdr)
+	hdr[sigHdrKey] = append([]string{sigHdr}, hdr[sigHdrKey]...)
+
+	return &mail.Message{Header: hdr, Body: bytes.NewReader(body)}, nil
+}
+
+// WriteMessage writes a message with the field order SignMessage signs
+// it in: DKIM-Signature fields first, followed by the other fields in
+// lexical order of their keys.
+func WriteMessage(w io.Writer, m *mail.Message) error {
+	var buf bytes.Buffer
+	for _, v := range m.Header[sigHdrKey] {
+		buf.WriteString(sigField(v))
+	}
+	rest := make(mail.Header, len(m.Header))
+	for k, v := range m.Header {
+		if k != sigHdrKey {
+			rest[k] = v
+		}
+	}
+	writeHeader(&buf, rest)
+	buf.WriteString("\r\n")
+	if _, err := buf.WriteTo(w); err != nil {
+		return err
+	}
+	_, err := io.Copy(w, m.Body)
+	return err
+}
+
+func writeHeader(buf *bytes.Buffer, hdr mail.Header) {
+	keys := make([]string, 0, len(hdr))
+	for k := range hdr {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range hdr[k] {
+			buf.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+}
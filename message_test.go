@@ -0,0 +1,43 @@
+package opendkim
+
+import (
+	"bytes"
+	"net/mail"
+	"testing"
+)
+
+func TestSignMessage(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	m, err := mail.ReadMessage(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	signed, err := d.SignMessage(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.Header.Get("DKIM-Signature") == "" {
+		t.Fatal(signed.Header)
+	}
+	if signed.Header.Get("Subject") != msgHdr["Subject"] {
+		t.Fatal(signed.Header)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, signed); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte(sigHdrPrefix)) {
+		t.Fatalf("%q", buf.Bytes())
+	}
+	verify(lib, buf.Bytes(), t).Destroy()
+}
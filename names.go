@@ -0,0 +1,159 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"fmt"
+	"strings"
+)
+
+func (c Canon) String() string {
+	switch c {
+	case CanonSIMPLE:
+		return "simple"
+	case CanonRELAXED:
+		return "relaxed"
+	}
+	return "unknown"
+}
+
+func (s Sign) String() string {
+	switch s {
+	case SignDEFAULT:
+		return "default"
+	case SignRSASHA1:
+		return "rsa-sha1"
+	case SignRSASHA256:
+		return "rsa-sha256"
+	case SignED25519SHA256:
+		return "ed25519-sha256"
+	}
+	return "unknown"
+}
+
+var statusNames = [...]string{
+	StatusOK:            "OK",
+	StatusBADSIG:        "BADSIG",
+	StatusNOSIG:         "NOSIG",
+	StatusNOKEY:         "NOKEY",
+	StatusCANTVRFY:      "CANTVRFY",
+	StatusSYNTAX:        "SYNTAX",
+	StatusNORESOURCE:    "NORESOURCE",
+	StatusINTERNAL:      "INTERNAL",
+	StatusREVOKED:       "REVOKED",
+	StatusINVALID:       "INVALID",
+	StatusNOTIMPLEMENT:  "NOTIMPLEMENT",
+	StatusKEYFAIL:       "KEYFAIL",
+	StatusCBREJECT:      "CBREJECT",
+	StatusCBINVALID:     "CBINVALID",
+	StatusCBTRYAGAIN:    "CBTRYAGAIN",
+	StatusCBERROR:       "CBERROR",
+	StatusMULTIDNSREPLY: "MULTIDNSREPLY",
+	StatusSIGGEN:        "SIGGEN",
+}
+
+// name returns the name of the status constant without its prefix.
+func (s Status) name() string {
+	if s >= 0 && int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+var optionNames = [...]string{
+	OptionFLAGS:        "FLAGS",
+	OptionTMPDIR:       "TMPDIR",
+	OptionTIMEOUT:      "TIMEOUT",
+	OptionSENDERHDRS:   "SENDERHDRS",
+	OptionSIGNHDRS:     "SIGNHDRS",
+	OptionOVERSIGNHDRS: "OVERSIGNHDRS",
+	OptionQUERYMETHOD:  "QUERYMETHOD",
+	OptionQUERYINFO:    "QUERYINFO",
+	OptionFIXEDTIME:    "FIXEDTIME",
+	OptionSKIPHDRS:     "SKIPHDRS",
+	OptionALWAYSHDRS:   "ALWAYSHDRS",
+	OptionSIGNATURETTL: "SIGNATURETTL",
+	OptionCLOCKDRIFT:   "CLOCKDRIFT",
+	OptionMUSTBESIGNED: "MUSTBESIGNED",
+	OptionMINKEYBITS:   "MINKEYBITS",
+	OptionREQUIREDHDRS: "REQUIREDHDRS",
+}
+
+func (o Option) String() string {
+	if o >= 0 && int(o) < len(optionNames) {
+		return optionNames[o]
+	}
+	return fmt.Sprintf("Option(%d)", int(o))
+}
+
+var sigflagNames = []struct {
+	f    Sigflag
+	name string
+}{
+	{SigflagIGNORE, "IGNORE"},
+	{SigflagPROCESSED, "PROCESSED"},
+	{SigflagPASSED, "PASSED"},
+	{SigflagTESTKEY, "TESTKEY"},
+	{SigflagNOSUBDOMAIN, "NOSUBDOMAIN"},
+	{SigflagKEYLOADED, "KEYLOADED"},
+}
+
+// String renders the set flags separated by "|", e.g. "PROCESSED|PASSED".
+// Unknown bits are rendered in hex.
+func (f Sigflag) String() string {
+	if f == 0 {
+		return "0"
+	}
+	var names []string
+	for _, n := range sigflagNames {
+		if f&n.f != 0 {
+			names = append(names, n.name)
+			f &^= n.f
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint(f)))
+	}
+	return strings.Join(names, "|")
+}
+
+var libflagNames = []struct {
+	f    Libflag
+	name string
+}{
+	{LibflagsTMPFILES, "TMPFILES"},
+	{LibflagsKEEPFILES, "KEEPFILES"},
+	{LibflagsSIGNLEN, "SIGNLEN"},
+	{LibflagsCACHE, "CACHE"},
+	{LibflagsZTAGS, "ZTAGS"},
+	{LibflagsDELAYSIGPROC, "DELAYSIGPROC"},
+	{LibflagsEOHCHECK, "EOHCHECK"},
+	{LibflagsACCEPTV05, "ACCEPTV05"},
+	{LibflagsFIXCRLF, "FIXCRLF"},
+	{LibflagsACCEPTDK, "ACCEPTDK"},
+	{LibflagsBADSIGHANDLES, "BADSIGHANDLES"},
+	{LibflagsVERIFYONE, "VERIFYONE"},
+	{LibflagsSTRICTHDRS, "STRICTHDRS"},
+	{LibflagsREPORTBADADSP, "REPORTBADADSP"},
+	{LibflagsDROPSIGNER, "DROPSIGNER"},
+	{LibflagsSTRICTRESIGN, "STRICTRESIGN"},
+}
+
+// String renders the set flags separated by "|", e.g. "CACHE|ZTAGS".
+// Unknown bits are rendered in hex.
+func (f Libflag) String() string {
+	if f == LibflagsNONE {
+		return "NONE"
+	}
+	var names []string
+	for _, n := range libflagNames {
+		if f&n.f != 0 {
+			names = append(names, n.name)
+			f &^= n.f
+		}
+	}
+	if f != 0 {
+		names = append(names, fmt.Sprintf("%#x", uint(f)))
+	}
+	return strings.Join(names, "|")
+}
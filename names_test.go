@@ -0,0 +1,35 @@
+package opendkim
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	for _, tc := range []struct {
+		v    fmt.Stringer
+		want string
+	}{
+		{CanonSIMPLE, "simple"},
+		{CanonRELAXED, "relaxed"},
+		{CanonUNKNOWN, "unknown"},
+		{SignRSASHA1, "rsa-sha1"},
+		{SignRSASHA256, "rsa-sha256"},
+		{SignED25519SHA256, "ed25519-sha256"},
+		{SignUNKNOWN, "unknown"},
+		{OptionTIMEOUT, "TIMEOUT"},
+		{OptionREQUIREDHDRS, "REQUIREDHDRS"},
+		{Option(99), "Option(99)"},
+		{Sigflag(0), "0"},
+		{SigflagPASSED, "PASSED"},
+		{SigflagPROCESSED | SigflagPASSED, "PROCESSED|PASSED"},
+		{SigflagIGNORE | SigflagKEYLOADED | 0x100, "IGNORE|KEYLOADED|0x100"},
+		{LibflagsNONE, "NONE"},
+		{LibflagsFIXCRLF | LibflagsZTAGS | LibflagsCACHE, "CACHE|ZTAGS|FIXCRLF"},
+		{LibflagsSTRICTRESIGN | 0x10000, "STRICTRESIGN|0x10000"},
+	} {
+		if s := tc.v.String(); s != tc.want {
+			t.Errorf("%#v: %q != %q", tc.v, s, tc.want)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// +build !windows
+
+package opendkim
+
+/*
+#include <stdlib.h>
+#include <stdint.h>
+#include <sys/types.h>
+*/
+import "C"
+
+import (
+	"bytes"
+	"time"
+	"unsafe"
+)
+
+// SetTmpDir sets the directory used for temporary files.
+func (lib *Lib) SetTmpDir(dir string) Status {
+	return lib.setString(OptionTMPDIR, dir)
+}
+
+// SetTimeout sets the timeout for DNS key queries.
+// The library works with whole seconds.
+func (lib *Lib) SetTimeout(d time.Duration) Status {
+	return lib.setUint(OptionTIMEOUT, uint(d/time.Second))
+}
+
+// SetMinKeyBits sets the minimum key size accepted when verifying.
+func (lib *Lib) SetMinKeyBits(bits int) Status {
+	return lib.setUint(OptionMINKEYBITS, uint(bits))
+}
+
+// SetSignatureTTL sets the lifetime of generated signatures (x=).
+// A zero duration disables expiration.
+func (lib *Lib) SetSignatureTTL(d time.Duration) Status {
+	return lib.setUint64(OptionSIGNATURETTL, uint64(d/time.Second))
+}
+
+// SetClockDrift sets the tolerated clock drift when checking signature
+// timestamps.
+func (lib *Lib) SetClockDrift(d time.Duration) Status {
+	return lib.setUint64(OptionCLOCKDRIFT, uint64(d/time.Second))
+}
+
+// SetFixedTime pins the time used for signature timestamps (t=), which
+// makes signatures reproducible.
+func (lib *Lib) SetFixedTime(t time.Time) Status {
+	return lib.setUint64(OptionFIXEDTIME, uint64(t.Unix()))
+}
+
+// SetSignHeaders sets the header fields to include in signatures.
+func (lib *Lib) SetSignHeaders(hdrs []string) Status {
+	return lib.setStrings(OptionSIGNHDRS, hdrs)
+}
+
+// SetOversignHeaders sets the header fields to oversign, i.e. to list in
+// h= once more than they occur, so additional instances can't be added
+// without invalidating the signature.
+func (lib *Lib) SetOversignHeaders(hdrs []string) Status {
+	return lib.setStrings(OptionOVERSIGNHDRS, hdrs)
+}
+
+// SetRequiredHeaders sets the header fields a signature has to list in
+// its h= tag to be accepted, whether or not the message has them.
+// Signatures missing one fail with SigErrorINVALIDH. This replaces the
+// library default, which only requires From, so hdrs should include it.
+func (lib *Lib) SetRequiredHeaders(hdrs []string) Status {
+	return lib.setStrings(OptionREQUIREDHDRS, hdrs)
+}
+
+// SetMustBeSigned sets the header fields that have to be signed if the
+// message has them. Signatures not covering one that is present fail
+// with SigErrorMBSFAILED.
+func (lib *Lib) SetMustBeSigned(hdrs []string) Status {
+	return lib.setStrings(OptionMUSTBESIGNED, hdrs)
+}
+
+// SetFlags sets the library flags, replacing the current ones.
+func (lib *Lib) SetFlags(flags Libflag) Status {
+	return lib.setUint(OptionFLAGS, uint(flags))
+}
+
+// Flags returns the library flags.
+func (lib *Lib) Flags() Libflag {
+	var flags C.uint
+	lib.options(GetOpt, OptionFLAGS, unsafe.Pointer(&flags), unsafe.Sizeof(flags))
+	return Libflag(flags)
+}
+
+// SetFixCRLF makes the library treat bare LF line endings as CRLF, as
+// found in messages stored in Unix files, while keeping the other flags.
+// With it set, Sign and Verify also convert the message to CRLF before
+// passing it on, so the message returned by Sign is the one that was
+// signed. Messages passed to Header, Body or Chunk directly are only
+// fixed by the library.
+func (lib *Lib) SetFixCRLF(fix bool) Status {
+	return lib.setFlag(LibflagsFIXCRLF, fix)
+}
+
+// SetVerifyOne makes Eom stop evaluating signatures after the first one
+// that passes, while keeping the other flags. Signatures after it are
+// left unprocessed and their keys aren't fetched.
+func (lib *Lib) SetVerifyOne(one bool) Status {
+	return lib.setFlag(LibflagsVERIFYONE, one)
+}
+
+// SetDNSSECMode sets the DNSSEC status the keys of passing signatures
+// must have. Every signature verified with a key that doesn't meet the
+// requirement is reported as not passed: its flags lack SigflagPASSED,
+// so VerifyDetailed, AlignedDomains and AuthenticationResults don't count
+// it as a pass, and if no other signature passes, Eom returns
+// StatusCANTVRFY instead of StatusOK. Any requirement rejects keys whose
+// validation failed (DNSSECBogus). The default is DNSSECModeNone.
+//
+// A requirement needs a libopendkim built with FeatureDNSSEC, otherwise
+// StatusNOTIMPLEMENT is returned. Only resolvers that validate DNSSEC
+// report the status of keys, like the system resolver or a
+// DNSSECResolver set with SetResolver; keys read from a file, see
+// SetQueryMethodFile, are always DNSSECUnknown.
+func (lib *Lib) SetDNSSECMode(mode DNSSECMode) Status {
+	if mode < DNSSECModeNone || mode > DNSSECModeSecure {
+		return StatusINVALID
+	}
+	if mode != DNSSECModeNone && !lib.HasFeature(FeatureDNSSEC) {
+		return StatusNOTIMPLEMENT
+	}
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	lib.dnssec = mode
+	return StatusOK
+}
+
+// SetQueryMethodFile makes the library look up keys in a local file
+// instead of DNS, e.g. for testing without network access. Each line of
+// the file holds the query name and the key record, separated by
+// whitespace:
+//
+//	selector._domainkey.example.com v=DKIM1; k=rsa; p=MIIBIjANBg...
+func (lib *Lib) SetQueryMethodFile(path string) Status {
+	if stat := lib.setQueryMethod(QueryFILE); stat != StatusOK {
+		return stat
+	}
+	return lib.setString(OptionQUERYINFO, path)
+}
+
+func (lib *Lib) setQueryMethod(method int) Status {
+	cv := C.int(method)
+	return lib.options(SetOpt, OptionQUERYMETHOD, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+}
+
+// derive returns a new library handle with the options, header lists and
+// callbacks of lib, so it can be configured further without affecting
+// lib. The handle must be closed once done.
+func (lib *Lib) derive() (*Lib, Status) {
+	n := Init()
+	stat := lib.copyOptions(n)
+	if stat != StatusOK {
+		n.Close()
+		return nil, stat
+	}
+
+	lib.mtx.Lock()
+	r, final, prescreen, keyLookup, dnssec := lib.resolver, lib.final, lib.prescreen, lib.keyLookup, lib.dnssec
+	lists := make(map[Option][]string, len(lib.lists))
+	for opt, v := range lib.lists {
+		lists[opt] = v
+	}
+	lib.mtx.Unlock()
+
+	for opt, v := range lists {
+		if stat = n.setStrings(opt, v); stat != StatusOK {
+			n.Close()
+			return nil, stat
+		}
+	}
+
+	if r != nil {
+		n.SetResolver(r)
+	}
+	if final != nil {
+		stat = n.SetFinal(final)
+	}
+	if prescreen != nil && stat == StatusOK {
+		stat = n.SetPrescreen(prescreen)
+	}
+	if keyLookup != nil && stat == StatusOK {
+		stat = n.SetKeyLookup(keyLookup)
+	}
+	if stat != StatusOK {
+		n.Close()
+		return nil, stat
+	}
+	n.dnssec = dnssec
+	return n, StatusOK
+}
+
+// copyOptions copies the scalar and string options of lib to n.
+func (lib *Lib) copyOptions(n *Lib) Status {
+	for _, opt := range []Option{OptionFLAGS, OptionTIMEOUT, OptionMINKEYBITS, OptionQUERYMETHOD} {
+		var v C.uint
+		if stat := lib.options(GetOpt, opt, unsafe.Pointer(&v), unsafe.Sizeof(v)); stat != StatusOK {
+			return stat
+		}
+		if stat := n.options(SetOpt, opt, unsafe.Pointer(&v), unsafe.Sizeof(v)); stat != StatusOK {
+			return stat
+		}
+	}
+	for _, opt := range []Option{OptionFIXEDTIME, OptionSIGNATURETTL, OptionCLOCKDRIFT} {
+		var v C.uint64_t
+		if stat := lib.options(GetOpt, opt, unsafe.Pointer(&v), unsafe.Sizeof(v)); stat != StatusOK {
+			return stat
+		}
+		if stat := n.options(SetOpt, opt, unsafe.Pointer(&v), unsafe.Sizeof(v)); stat != StatusOK {
+			return stat
+		}
+	}
+	for _, opt := range []Option{OptionTMPDIR, OptionQUERYINFO} {
+		buf := make([]byte, 4096)
+		if stat := lib.options(GetOpt, opt, unsafe.Pointer(&buf[0]), uintptr(len(buf))); stat != StatusOK {
+			return stat
+		}
+		if i := bytes.IndexByte(buf, 0); i > 0 {
+			if stat := n.setString(opt, string(buf[:i])); stat != StatusOK {
+				return stat
+			}
+		}
+	}
+	return StatusOK
+}
+
+func (lib *Lib) setFlag(f Libflag, on bool) Status {
+	flags := lib.Flags()
+	if on {
+		flags |= f
+	} else {
+		flags &^= f
+	}
+	return lib.SetFlags(flags)
+}
+
+func (lib *Lib) setUint(opt Option, v uint) Status {
+	cv := C.uint(v)
+	return lib.options(SetOpt, opt, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+}
+
+func (lib *Lib) setUint64(opt Option, v uint64) Status {
+	cv := C.uint64_t(v)
+	return lib.options(SetOpt, opt, unsafe.Pointer(&cv), unsafe.Sizeof(cv))
+}
+
+func (lib *Lib) setString(opt Option, v string) Status {
+	cv := C.CString(v)
+	defer C.free(unsafe.Pointer(cv))
+
+	return lib.options(SetOpt, opt, unsafe.Pointer(cv), uintptr(len(v)))
+}
+
+// setStrings passes a NULL-terminated string array, which the library
+// copies before returning. The list is also kept on lib, as the library
+// can't return it, so derive can copy it.
+func (lib *Lib) setStrings(opt Option, v []string) Status {
+	arr := cStringArray(v)
+	defer freeCStringArray(arr)
+
+	stat := lib.options(SetOpt, opt, unsafe.Pointer(arr), unsafe.Sizeof(arr))
+	if stat != StatusOK {
+		return stat
+	}
+	lib.mtx.Lock()
+	defer lib.mtx.Unlock()
+
+	if lib.lists == nil {
+		lib.lists = make(map[Option][]string)
+	}
+	lib.lists[opt] = append([]string(nil), v...)
+	return stat
+}
+
+// cStringArray allocates a NULL-terminated C string array holding v.
+// It must be released with freeCStringArray.
+func cStringArray(v []string) **C.char {
+	arr := (**C.char)(C.calloc(C.size_t(len(v)+1), C.size_t(unsafe.Sizeof((*C.char)(nil)))))
+	ptrs := unsafe.Slice(arr, len(v)+1)
+	for i, s := range v {
+		ptrs[i] = C.CString(s)
+	}
+	return arr
+}
+
+func freeCStringArray(arr **C.char) {
+	for p := arr; *p != nil; p = (**C.char)(unsafe.Add(unsafe.Pointer(p), unsafe.Sizeof(*p))) {
+		C.free(unsafe.Pointer(*p))
+	}
+	C.free(unsafe.Pointer(arr))
+}
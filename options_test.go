@@ -0,0 +1,383 @@
+package opendkim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+func TestSetTmpDir(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetTmpDir("/var/tmp"); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	buf := make([]byte, 256)
+	lib.Options(GetOpt, OptionTMPDIR, unsafe.Pointer(&buf[0]), uintptr(len(buf)))
+	if x := string(buf[:bytes.IndexByte(buf, 0)]); x != "/var/tmp" {
+		t.Fatal(x)
+	}
+}
+
+func TestSetTimeout(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetTimeout(7 * time.Second); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	var v uint32
+	lib.Options(GetOpt, OptionTIMEOUT, unsafe.Pointer(&v), unsafe.Sizeof(v))
+	if v != 7 {
+		t.Fatal(v)
+	}
+}
+
+func TestSetMinKeyBits(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetMinKeyBits(2048); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	var v uint32
+	lib.Options(GetOpt, OptionMINKEYBITS, unsafe.Pointer(&v), unsafe.Sizeof(v))
+	if v != 2048 {
+		t.Fatal(v)
+	}
+}
+
+func TestSetSignatureTTL(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetSignatureTTL(time.Hour); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	var v uint64
+	lib.Options(GetOpt, OptionSIGNATURETTL, unsafe.Pointer(&v), unsafe.Sizeof(v))
+	if v != 3600 {
+		t.Fatal(v)
+	}
+}
+
+func TestSetClockDrift(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetClockDrift(5 * time.Minute); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	var v uint64
+	lib.Options(GetOpt, OptionCLOCKDRIFT, unsafe.Pointer(&v), unsafe.Sizeof(v))
+	if v != 300 {
+		t.Fatal(v)
+	}
+}
+
+func TestSetFixedTime(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetFixedTime(time.Unix(1362325420, 0)); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	msg := createMsg(msgHdr, msgBody)
+	a := sign(lib, msg, t)
+	b := sign(lib, msg, t)
+	if !bytes.Equal(a, b) {
+		t.Fatal(string(a), string(b))
+	}
+	if x := sigTag(a, "t", t); x != "1362325420" {
+		t.Fatal(x)
+	}
+}
+
+func TestSetOversignHeaders(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetOversignHeaders([]string{"Subject"}); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+	h := strings.ToLower(sigTag(out, "h", t))
+	if n := strings.Count(h, "subject"); n != 2 {
+		t.Fatal(h)
+	}
+}
+
+func TestSetSignHeaders(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetSignHeaders([]string{"From", "To", "Subject"}); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+	h := strings.ToLower(sigTag(out, "h", t))
+	if strings.Contains(h, "date") || !strings.Contains(h, "from") {
+		t.Fatal(h)
+	}
+}
+
+func TestSetRequiredHeaders(t *testing.T) {
+	testSignedHeaderPolicy(t, (*Lib).SetRequiredHeaders, []string{"From", "Subject"}, SigErrorINVALIDH)
+}
+
+func TestSetMustBeSigned(t *testing.T) {
+	testSignedHeaderPolicy(t, (*Lib).SetMustBeSigned, []string{"Subject"}, SigErrorMBSFAILED)
+}
+
+// testSignedHeaderPolicy checks that a message signed without Subject
+// passes, but fails with sigErr once set requires hdrs.
+func testSignedHeaderPolicy(t *testing.T, set func(*Lib, []string) Status, hdrs []string, sigErr SigError) {
+	signer := testLib(t)
+	defer signer.Close()
+
+	if stat := signer.SetSignHeaders([]string{"From", "To", "Date"}); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	msg := sign(signer, createMsg(msgHdr, msgBody), t)
+
+	lib := testLib(t)
+	defer lib.Close()
+
+	verify(lib, msg, t).Destroy()
+
+	if stat := set(lib, hdrs); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	if stat = d.Verify(bytes.NewReader(msg)); stat == StatusOK {
+		t.Fatal(stat)
+	}
+	sig := d.GetSignature()
+	if sig == nil || sig.Passed() || sig.Err() != sigErr {
+		t.Fatal(sig)
+	}
+}
+
+func TestSetFlags(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	flags := LibflagsFIXCRLF | LibflagsZTAGS | LibflagsCACHE
+	if stat := lib.SetFlags(flags); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if x := lib.Flags(); x != flags {
+		t.Fatal(x)
+	}
+	if stat := lib.SetFlags(LibflagsNONE); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if x := lib.Flags(); x != LibflagsNONE {
+		t.Fatal(x)
+	}
+}
+
+func TestSetFixCRLF(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	lib.SetFlags(LibflagsZTAGS)
+	if stat := lib.SetFixCRLF(true); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if x := lib.Flags(); x != LibflagsZTAGS|LibflagsFIXCRLF {
+		t.Fatal(x)
+	}
+
+	msg := strings.ReplaceAll(string(createMsg(msgHdr, msgBody+"second line\r\n")), "\r\n", "\n")
+	out := sign(lib, []byte(msg), t)
+	if bytes.Contains(bytes.ReplaceAll(out, []byte("\r\n"), nil), []byte("\n")) {
+		t.Fatalf("bare LF in %q", out)
+	}
+	verify(lib, out, t).Destroy()
+
+	// the original LF-only message verifies as well
+	sig := out[:bytes.Index(out, []byte("\r\n\r\n"))]
+	sig = sig[bytes.Index(sig, []byte(sigHdrPrefix)):]
+	verify(lib, append(append(sig, "\r\n"...), msg...), t).Destroy()
+
+	if stat := lib.SetFixCRLF(false); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if x := lib.Flags(); x != LibflagsZTAGS {
+		t.Fatal(x)
+	}
+}
+
+func TestFixCRLF(t *testing.T) {
+	for in, want := range map[string]string{
+		"":             "",
+		"a\r\nb\r\n":   "a\r\nb\r\n",
+		"a\nb\n":       "a\r\nb\r\n",
+		"\na\r\nb\n\n": "\r\na\r\nb\r\n\r\n",
+	} {
+		if out := string(fixCRLF([]byte(in))); out != want {
+			t.Errorf("%q: %q", in, out)
+		}
+	}
+}
+
+func TestSetVerifyOne(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	var lookups int
+	lib.SetKeyLookup(func(sig *Signature, domain, selector string) ([]byte, Status) {
+		lookups++
+		return []byte(testRecord(t)), StatusOK
+	})
+	msg := sign(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+
+	verify(lib, msg, t).Destroy()
+	if lookups != 2 {
+		t.Fatal(lookups)
+	}
+
+	if stat := lib.SetVerifyOne(true); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	lookups = 0
+	d := verify(lib, msg, t)
+	defer d.Destroy()
+
+	if lookups != 1 {
+		t.Fatal(lookups)
+	}
+	sigs, _ := d.GetSignatures()
+	if len(sigs) != 2 || sigs[0].Flags()&SigflagPASSED == 0 || sigs[1].Flags()&SigflagPROCESSED != 0 {
+		t.Fatal(sigs)
+	}
+}
+
+func TestSetDNSSECMode(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetDNSSECMode(DNSSECModeSecure + 1); stat != StatusINVALID {
+		t.Fatal(stat)
+	}
+	if !lib.HasFeature(FeatureDNSSEC) {
+		if stat := lib.SetDNSSECMode(DNSSECModeSecure); stat != StatusNOTIMPLEMENT {
+			t.Fatal(stat)
+		}
+		t.Skip("DNSSEC not supported by libopendkim")
+	}
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	// keys read from a file have an unknown DNSSEC status
+	for mode, want := range map[DNSSECMode]Status{
+		DNSSECModeNone:     StatusOK,
+		DNSSECModeNotBogus: StatusOK,
+		DNSSECModeInsecure: StatusCANTVRFY,
+		DNSSECModeSecure:   StatusCANTVRFY,
+	} {
+		if stat := lib.SetDNSSECMode(mode); stat != StatusOK {
+			t.Fatal(stat)
+		}
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		res, err := d.VerifyDetailed(bytes.NewReader(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Status != want || len(res.Signatures) != 1 {
+			t.Errorf("%d: %v", mode, res.Status)
+		}
+		// a rejected signature isn't reported as a pass anywhere
+		passed := res.Signatures[0].Flags&SigflagPASSED != 0
+		ar, _ := d.AuthenticationResults("mx.example.com")
+		aligned := len(d.AlignedDomains(domain, AlignStrict, nil)) > 0
+		if passed != (want == StatusOK) || strings.Contains(ar, "dkim=pass") != passed || aligned != passed {
+			t.Errorf("%d: %v %q", mode, res.Signatures[0].Flags, ar)
+		}
+		if !passed && !strings.Contains(ar, "dkim=policy") {
+			t.Errorf("%d: %q", mode, ar)
+		}
+		d.Destroy()
+	}
+}
+
+func TestSetDNSSECModeResolver(t *testing.T) {
+	signer := testLib(t)
+	defer signer.Close()
+
+	msg := sign(signer, createMsg(msgHdr, msgBody), t)
+
+	for _, tc := range []struct {
+		status DNSSEC
+		mode   DNSSECMode
+		want   Status
+	}{
+		{DNSSECInsecure, DNSSECModeInsecure, StatusOK},
+		{DNSSECInsecure, DNSSECModeSecure, StatusCANTVRFY},
+		{DNSSECSecure, DNSSECModeInsecure, StatusOK},
+		{DNSSECSecure, DNSSECModeSecure, StatusOK},
+		{DNSSECBogus, DNSSECModeNotBogus, StatusCANTVRFY},
+		{DNSSECBogus, DNSSECModeNone, StatusOK},
+	} {
+		lib := Init()
+		if !lib.HasFeature(FeatureDNSSEC) {
+			lib.Close()
+			t.Skip("DNSSEC not supported by libopendkim")
+		}
+		lib.SetResolver(&dnssecResolver{
+			mapResolver: newMapResolver(map[string]string{
+				selector + "._domainkey." + domain: testRecord(t),
+			}),
+			status: tc.status,
+		})
+		if stat := lib.SetDNSSECMode(tc.mode); stat != StatusOK {
+			t.Fatal(stat)
+		}
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		if stat = d.Verify(bytes.NewReader(msg)); stat != tc.want {
+			t.Errorf("%d with mode %d: %v", tc.status, tc.mode, stat)
+		}
+		if sig := d.GetSignature(); sig == nil || sig.DNSSEC() != tc.status {
+			t.Errorf("%d: %v", tc.status, sig)
+		}
+		d.Destroy()
+		lib.Close()
+	}
+}
+
+func TestDNSSECModeAccepts(t *testing.T) {
+	for _, tc := range []struct {
+		mode DNSSECMode
+		want []DNSSEC
+	}{
+		{DNSSECModeNone, []DNSSEC{DNSSECUnknown, DNSSECBogus, DNSSECInsecure, DNSSECSecure}},
+		{DNSSECModeNotBogus, []DNSSEC{DNSSECUnknown, DNSSECInsecure, DNSSECSecure}},
+		{DNSSECModeInsecure, []DNSSEC{DNSSECInsecure, DNSSECSecure}},
+		{DNSSECModeSecure, []DNSSEC{DNSSECSecure}},
+	} {
+		for _, s := range []DNSSEC{DNSSECUnknown, DNSSECBogus, DNSSECInsecure, DNSSECSecure} {
+			want := false
+			for _, w := range tc.want {
+				want = want || w == s
+			}
+			if x := tc.mode.accepts(s); x != want {
+				t.Errorf("%d accepts %d: %v", tc.mode, s, x)
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// VerifierPool hands out verifier handles and releases them eagerly.
+//
+// libopendkim has no way to reset a handle once it has processed a
+// message, so handles are not reused. What the pool saves is relying on
+// finalizers: Put frees the C side of a handle right away instead of
+// leaving it to the garbage collector, which keeps memory flat under
+// high throughput.
+//
+// For the same reason the pool doesn't keep handles in a sync.Pool:
+// a used handle can't be handed out again, and handles dropped by a
+// sync.Pool would be left to their finalizers after all.
+//
+// A VerifierPool is safe for concurrent use. Each handle must only be
+// used by one goroutine at a time and must not be used after Put. A
+// handle that is never put back is still freed by its finalizer.
+type VerifierPool struct {
+	lib *Lib
+}
+
+// NewVerifierPool creates a pool of verifiers for lib.
+func (lib *Lib) NewVerifierPool() *VerifierPool {
+	return &VerifierPool{lib: lib}
+}
+
+// Get returns a fresh verifier, or nil if it couldn't be created.
+func (p *VerifierPool) Get() *Dkim {
+	d, stat := p.lib.NewVerifier()
+	if stat != StatusOK {
+		return nil
+	}
+	return d
+}
+
+// Put releases a verifier obtained from Get.
+func (p *VerifierPool) Put(d *Dkim) {
+	if d == nil {
+		return
+	}
+	runtime.SetFinalizer(d, nil)
+	d.Destroy()
+}
+
+// LibPool is a fixed set of library handles that are handed out round
+// robin. Handles of a single Lib can already be used concurrently, but
+// they share the library's internal state, like the key cache and its
+// lock; a pool spreads that state across several handles.
+type LibPool struct {
+	libs []*Lib
+	next uint32
+}
+
+// NewLibPool initializes n library handles. Configure them with Each
+// before use.
+func NewLibPool(n int) *LibPool {
+	if n < 1 {
+		n = 1
+	}
+	p := &LibPool{libs: make([]*Lib, n)}
+	for i := range p.libs {
+		p.libs[i] = Init()
+	}
+	return p
+}
+
+// Lib returns the next library handle of the pool.
+func (p *LibPool) Lib() *Lib {
+	i := atomic.AddUint32(&p.next, 1)
+	return p.libs[int(i%uint32(len(p.libs)))]
+}
+
+// Each calls fn for every library handle of the pool, e.g. to set
+// options, stopping at the first status other than StatusOK.
+func (p *LibPool) Each(fn func(lib *Lib) Status) Status {
+	for _, lib := range p.libs {
+		if stat := fn(lib); stat != StatusOK {
+			return stat
+		}
+	}
+	return StatusOK
+}
+
+// Close closes all library handles of the pool.
+func (p *LibPool) Close() {
+	for _, lib := range p.libs {
+		lib.Close()
+	}
+}
+
+// VerifyBatch verifies the messages received from msgs using up to
+// workers goroutines, each message with a fresh handle. Results are
+// delivered in completion order; VerifyResult.Index is the position of
+// the message in msgs. If a message can't be read or processed, Err is
+// the error of VerifyDetailed. The returned channel is closed once msgs
+// is closed and all messages are verified, or ctx is done.
+//
+// Verification doesn't hold lib's lock, so workers only contend on it
+// when library callbacks are set.
+func (lib *Lib) VerifyBatch(ctx context.Context, msgs <-chan io.Reader, workers int) <-chan VerifyResult {
+	if workers < 1 {
+		workers = 1
+	}
+	type job struct {
+		idx int
+		r   io.Reader
+	}
+	jobs := make(chan job)
+	res := make(chan VerifyResult, workers)
+	pool := lib.NewVerifierPool()
+
+	go func() {
+		defer close(jobs)
+		for i := 0; ; i++ {
+			select {
+			case r, ok := <-msgs:
+				if !ok {
+					return
+				}
+				select {
+				case jobs <- job{i, r}:
+				case <-ctx.Done():
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				vr := VerifyResult{Status: StatusNORESOURCE, Err: StatusNORESOURCE}
+				if d := pool.Get(); d != nil {
+					if r, err := d.VerifyDetailed(j.r); err == nil {
+						vr = *r
+					} else {
+						// the library's status, or INTERNAL if the message couldn't be read
+						vr.Status, vr.Err = StatusINTERNAL, err
+						errors.As(err, &vr.Status)
+					}
+					pool.Put(d)
+				}
+				vr.Index = j.idx
+				select {
+				case res <- vr:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(res)
+	}()
+	return res
+}
@@ -0,0 +1,179 @@
+package opendkim
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"testing"
+)
+
+func TestVerifierPool(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+	pool := lib.NewVerifierPool()
+	for i := 0; i < 10; i++ {
+		d := pool.Get()
+		if d == nil {
+			t.Fatal()
+		}
+		if stat := d.Verify(bytes.NewReader(msg)); stat != StatusOK {
+			t.Fatal(stat)
+		}
+		pool.Put(d)
+	}
+}
+
+func TestVerifyBatch(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+	bad := append(append([]byte(nil), msg...), "tampered\r\n"...)
+
+	const n = 50
+	msgs := make(chan io.Reader)
+	go func() {
+		defer close(msgs)
+		for i := 0; i < n; i++ {
+			if i%5 == 0 {
+				msgs <- bytes.NewReader(bad)
+			} else {
+				msgs <- bytes.NewReader(msg)
+			}
+		}
+	}()
+
+	seen := make(map[int]bool)
+	for res := range lib.VerifyBatch(context.Background(), msgs, 4) {
+		if seen[res.Index] {
+			t.Fatal("duplicate result", res.Index)
+		}
+		seen[res.Index] = true
+
+		want := Status(StatusOK)
+		if res.Index%5 == 0 {
+			want = StatusBADSIG
+		}
+		if res.Status != want {
+			t.Fatal(res.Index, res.Status)
+		}
+		if len(res.Signatures) != 1 || res.Signatures[0].Domain != domain {
+			t.Fatal(res.Signatures)
+		}
+	}
+	if len(seen) != n {
+		t.Fatal(len(seen))
+	}
+}
+
+func TestVerifyBatchCancel(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	msgs := make(chan io.Reader)
+	res := lib.VerifyBatch(ctx, msgs, 2)
+	cancel()
+
+	// the result channel is closed although msgs never is
+	for range res {
+	}
+}
+
+func BenchmarkVerifyPerMessage(b *testing.B) {
+	lib := Init()
+	defer lib.Close()
+	lib.SetQueryMethodFile("testdata/keys")
+
+	msg := benchMsg(lib, b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d, _ := lib.NewVerifier()
+		d.Verify(bytes.NewReader(msg))
+	}
+}
+
+func BenchmarkVerifyPooled(b *testing.B) {
+	lib := Init()
+	defer lib.Close()
+	lib.SetQueryMethodFile("testdata/keys")
+
+	msg := benchMsg(lib, b)
+	pool := lib.NewVerifierPool()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		d := pool.Get()
+		d.Verify(bytes.NewReader(msg))
+		pool.Put(d)
+	}
+}
+
+func benchMsg(lib *Lib, b *testing.B) []byte {
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		b.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	out, err := d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return out
+}
+
+func TestLibPool(t *testing.T) {
+	pool := NewLibPool(3)
+	defer pool.Close()
+
+	stat := pool.Each(func(lib *Lib) Status {
+		return lib.SetQueryMethodFile("testdata/keys")
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+
+	seen := make(map[*Lib]bool)
+	for i := 0; i < 6; i++ {
+		lib := pool.Lib()
+		seen[lib] = true
+		verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t).Destroy()
+	}
+	if len(seen) != 3 {
+		t.Fatal(len(seen))
+	}
+}
+
+func benchVerifyParallel(b *testing.B, pool *LibPool) {
+	pool.Each(func(lib *Lib) Status {
+		return lib.SetQueryMethodFile("testdata/keys")
+	})
+	msg := benchMsg(pool.Lib(), b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			lib := pool.Lib()
+			d, _ := lib.NewVerifier()
+			d.Verify(bytes.NewReader(msg))
+			d.Destroy()
+		}
+	})
+}
+
+func BenchmarkVerifyParallelSingleLib(b *testing.B) {
+	pool := NewLibPool(1)
+	defer pool.Close()
+
+	benchVerifyParallel(b, pool)
+}
+
+func BenchmarkVerifyParallelLibPool(b *testing.B) {
+	pool := NewLibPool(runtime.GOMAXPROCS(0))
+	defer pool.Close()
+
+	benchVerifyParallel(b, pool)
+}
@@ -0,0 +1,69 @@
+// +build !windows
+
+package opendkim
+
+/*
+#cgo linux LDFLAGS: -ldl
+
+#define _GNU_SOURCE
+#include <stdlib.h>
+#include <dlfcn.h>
+#include <sys/types.h>
+#include <dkim.h>
+
+// dkim_get_reputation is only built into libopendkim with reputation
+// support, so it is looked up at runtime instead of being linked.
+typedef DKIM_STAT (*go_reputation_fn)(DKIM *, DKIM_SIGINFO *, char *, int *);
+
+static void *
+go_lookup_reputation(void)
+{
+	return dlsym(RTLD_DEFAULT, "dkim_get_reputation");
+}
+
+static DKIM_STAT
+go_get_reputation(void *fn, DKIM *dkim, DKIM_SIGINFO *sig, int *rep)
+{
+	return ((go_reputation_fn) fn)(dkim, sig, NULL, rep);
+}
+*/
+import "C"
+
+import (
+	"sync"
+	"unsafe"
+)
+
+var reputation struct {
+	once sync.Once
+	fn   unsafe.Pointer // dkim_get_reputation, or nil
+}
+
+// Reputation queries the reputation of the signature's d= domain from
+// the library's default reputation service. It requires a libopendkim
+// built with reputation support and returns StatusNOTIMPLEMENT otherwise.
+func (s *Signature) Reputation() (int, Status) {
+	if !s.hold() {
+		return 0, StatusINVALID
+	}
+	defer s.release()
+
+	if !hasReputation() {
+		return 0, StatusNOTIMPLEMENT
+	}
+	var rep C.int
+	stat := Status(C.go_get_reputation(reputation.fn, s.h.dkim, s.sig, &rep))
+	if stat != StatusOK {
+		return 0, stat
+	}
+	return int(rep), stat
+}
+
+// hasReputation reports whether libopendkim was built with reputation
+// support. The library has no feature bit for it.
+func hasReputation() bool {
+	reputation.once.Do(func() {
+		reputation.fn = C.go_lookup_reputation()
+	})
+	return reputation.fn != nil
+}
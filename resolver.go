@@ -0,0 +1,87 @@
+package opendkim
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DNS record type of DKIM key queries.
+const TypeTXT = 16
+
+// ErrNoReply is returned by Resolver.WaitReply if no reply arrived
+// within the timeout.
+var ErrNoReply = errors.New("no DNS reply")
+
+// Resolver is a DNS query service the library can use for key lookups
+// instead of the system resolver; see Lib.SetResolver.
+type Resolver interface {
+	// Start starts a query for records of type qtype for name and
+	// returns an identifier for it.
+	Start(qtype int, name string) (id int, err error)
+
+	// Cancel releases a query. It is called for every started query,
+	// whether or not a reply was received.
+	Cancel(id int)
+
+	// WaitReply waits for the reply to a query and returns it in DNS
+	// wire format. A negative timeout means to wait indefinitely.
+	WaitReply(id int, timeout time.Duration) ([]byte, error)
+}
+
+// DNSSECResolver is a Resolver that also reports the DNSSEC status of
+// its replies, e.g. one backed by a validating resolver. Without it, keys
+// looked up through a Resolver are DNSSECUnknown; see Lib.SetDNSSECMode.
+type DNSSECResolver interface {
+	Resolver
+
+	// WaitReplyDNSSEC is like WaitReply, but also returns the DNSSEC
+	// status of the reply. It is called instead of WaitReply.
+	WaitReplyDNSSEC(id int, timeout time.Duration) ([]byte, DNSSEC, error)
+}
+
+// TXTReply builds a DNS reply in wire format answering a TXT query for
+// name with the given records. It is meant for Resolver implementations
+// that don't talk DNS themselves, e.g. ones serving keys from memory.
+func TXTReply(name string, records ...string) []byte {
+	qname := encodeName(name)
+
+	msg := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(msg[2:], 0x8180) // response, recursion desired and available
+	binary.BigEndian.PutUint16(msg[4:], 1)
+	binary.BigEndian.PutUint16(msg[6:], uint16(len(records)))
+
+	msg = append(msg, qname...)
+	msg = binary.BigEndian.AppendUint16(msg, TypeTXT)
+	msg = binary.BigEndian.AppendUint16(msg, 1) // IN
+
+	for _, rec := range records {
+		var rdata []byte
+		for len(rec) > 0 || rdata == nil {
+			n := len(rec)
+			if n > 255 {
+				n = 255
+			}
+			rdata = append(rdata, byte(n))
+			rdata = append(rdata, rec[:n]...)
+			rec = rec[n:]
+		}
+		msg = binary.BigEndian.AppendUint16(msg, 0xc00c) // pointer to the question name
+		msg = binary.BigEndian.AppendUint16(msg, TypeTXT)
+		msg = binary.BigEndian.AppendUint16(msg, 1)
+		msg = binary.BigEndian.AppendUint32(msg, 300)
+		msg = binary.BigEndian.AppendUint16(msg, uint16(len(rdata)))
+		msg = append(msg, rdata...)
+	}
+	return msg
+}
+
+func encodeName(name string) []byte {
+	var b []byte
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
@@ -0,0 +1,226 @@
+package opendkim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapResolver answers TXT queries from a map.
+type mapResolver struct {
+	mtx     sync.Mutex
+	records map[string]string
+	queries map[int]string
+	names   []string
+	next    int
+}
+
+func newMapResolver(records map[string]string) *mapResolver {
+	return &mapResolver{records: records, queries: make(map[int]string)}
+}
+
+func (r *mapResolver) Start(qtype int, name string) (int, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	r.next++
+	r.queries[r.next] = name
+	r.names = append(r.names, name)
+	return r.next, nil
+}
+
+func (r *mapResolver) Cancel(id int) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.queries, id)
+}
+
+func (r *mapResolver) WaitReply(id int, timeout time.Duration) ([]byte, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	name := r.queries[id]
+	if rec, ok := r.records[name]; ok {
+		return TXTReply(name, rec), nil
+	}
+	return TXTReply(name), nil
+}
+
+func testRecord(t *testing.T) string {
+	keys, err := os.ReadFile("testdata/keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := string(keys[:bytes.IndexByte(keys, '\n')])
+	return line[strings.IndexByte(line, ' ')+1:]
+}
+
+func TestTXTReply(t *testing.T) {
+	rec := strings.Repeat("x", 300)
+	msg := TXTReply("a.example.com", rec)
+
+	// header, question and answer header
+	qlen := len("a.example.com") + 2 + 4
+	if n := 12 + qlen + 12 + 1 + 255 + 1 + 45; len(msg) != n {
+		t.Fatal(len(msg), n)
+	}
+	if !bytes.HasSuffix(msg, []byte(strings.Repeat("x", 45))) {
+		t.Fatal(msg)
+	}
+}
+
+func TestSetResolver(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	r := newMapResolver(map[string]string{
+		selector + "._domainkey." + domain: testRecord(t),
+	})
+	lib.SetResolver(r)
+
+	verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t).Destroy()
+
+	if len(r.names) != 1 || r.names[0] != selector+"._domainkey."+domain {
+		t.Fatal(r.names)
+	}
+	if len(r.queries) != 0 {
+		t.Fatal("query not cancelled")
+	}
+}
+
+// flakyResolver answers the first query for each name with duplicate
+// records, as seen when a selector is published twice.
+type flakyResolver struct {
+	*mapResolver
+	answered map[string]bool
+}
+
+func (r *flakyResolver) WaitReply(id int, timeout time.Duration) ([]byte, error) {
+	r.mtx.Lock()
+	name := r.queries[id]
+	rec, ok := r.records[name]
+	first := !r.answered[name]
+	r.answered[name] = true
+	r.mtx.Unlock()
+
+	if ok && first {
+		return TXTReply(name, rec, rec), nil
+	}
+	return r.mapResolver.WaitReply(id, timeout)
+}
+
+func TestEomWithRetry(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	r := &flakyResolver{
+		mapResolver: newMapResolver(map[string]string{
+			selector + "._domainkey." + domain: testRecord(t),
+		}),
+		answered: make(map[string]bool),
+	}
+	lib.SetResolver(r)
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	br := bufio.NewReader(bytes.NewReader(msg))
+	if _, stat = d.processHeader(br); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	body, _ := io.ReadAll(br)
+	if stat = d.Body(body); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	stat = d.EomWithRetry(RetryPolicy{Attempts: 3, Backoff: time.Millisecond}, nil)
+	if stat != StatusOK {
+		t.Log(d.GetError())
+		t.Fatal(stat)
+	}
+	if len(r.names) != 2 {
+		t.Fatal(r.names)
+	}
+}
+
+// slowResolver doesn't answer until release is closed.
+type slowResolver struct {
+	*mapResolver
+	release chan struct{}
+}
+
+func (r *slowResolver) WaitReply(id int, timeout time.Duration) ([]byte, error) {
+	<-r.release
+	return r.mapResolver.WaitReply(id, timeout)
+}
+
+// dnssecResolver reports all replies with the same DNSSEC status.
+type dnssecResolver struct {
+	*mapResolver
+	status DNSSEC
+}
+
+func (r *dnssecResolver) WaitReplyDNSSEC(id int, timeout time.Duration) ([]byte, DNSSEC, error) {
+	reply, err := r.mapResolver.WaitReply(id, timeout)
+	return reply, r.status, err
+}
+
+func TestVerifyContext(t *testing.T) {
+	lib := Init()
+	defer lib.Close()
+
+	r := &slowResolver{
+		mapResolver: newMapResolver(map[string]string{
+			selector + "._domainkey." + domain: testRecord(t),
+		}),
+		release: make(chan struct{}),
+	}
+	lib.SetResolver(r)
+
+	signer, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer signer.Destroy()
+
+	msg, err := signer.SignContext(context.Background(), bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	// the lookup finishes in the background once released
+	defer d.Destroy()
+	defer close(r.release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if stat = d.VerifyContext(ctx, bytes.NewReader(msg)); !stat.IsTempFail() {
+		t.Fatal(stat)
+	}
+	if x := time.Since(start); x > time.Second {
+		t.Fatal(x)
+	}
+
+	// a done context fails right away
+	if _, err := signer.SignContext(ctx, bytes.NewReader(createMsg(msgHdr, msgBody))); !errors.Is(err, StatusCBTRYAGAIN) {
+		t.Fatal(err)
+	}
+}
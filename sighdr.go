@@ -0,0 +1,58 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// SigHeader holds the tags of a DKIM-Signature header relevant for
+// routing, as parsed by ParseSignatureHeaders.
+type SigHeader struct {
+	Domain      string // d=
+	Selector    string // s=
+	Algorithm   Sign   // a=, SignUNKNOWN if not supported
+	HeaderCanon Canon  // c=, simple if missing
+	BodyCanon   Canon
+}
+
+// ParseSignatureHeaders returns the signatures found in the header of a
+// raw message, without verifying them or looking up any keys. Signatures
+// lacking d= or s= are skipped. An error is returned if the header block
+// is malformed.
+func ParseSignatureHeaders(raw []byte) ([]SigHeader, error) {
+	fields, err := readHeader(bufio.NewReader(bytes.NewReader(raw)))
+	if err != nil {
+		return nil, err
+	}
+
+	var res []SigHeader
+	for _, f := range fields {
+		if !strings.EqualFold(fieldName(f), "dkim-signature") {
+			continue
+		}
+		tags := parseTags(f[strings.IndexByte(f, ':')+1:])
+		if tags["d"] == "" || tags["s"] == "" {
+			continue
+		}
+		h := SigHeader{
+			Domain:    tags["d"],
+			Selector:  tags["s"],
+			Algorithm: parseSign(tags["a"]),
+		}
+		h.HeaderCanon, h.BodyCanon = parseCanon(tags["c"])
+		res = append(res, h)
+	}
+	return res, nil
+}
+
+func parseSign(v string) Sign {
+	for _, s := range []Sign{SignRSASHA1, SignRSASHA256, SignED25519SHA256} {
+		if strings.EqualFold(v, s.String()) {
+			return s
+		}
+	}
+	return SignUNKNOWN
+}
@@ -0,0 +1,43 @@
+package opendkim
+
+import "testing"
+
+func TestParseSignatureHeaders(t *testing.T) {
+	msg := "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/simple; d=example.org;\r\n" +
+		"\ts=sel1; h=from:subject; bh=abc=; b=def=\r\n" +
+		"dkim-signature: v=1; a=ed25519-sha256; d=erikk.org; s=sel2; h=from; bh=x; b=y\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; s=nodomain; h=from; bh=x; b=y\r\n" +
+		"From: a@b.com\r\n" +
+		"\r\n" +
+		"body\r\n"
+
+	sigs, err := ParseSignatureHeaders([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SigHeader{
+		{"example.org", "sel1", SignRSASHA256, CanonRELAXED, CanonSIMPLE},
+		{"erikk.org", "sel2", SignED25519SHA256, CanonSIMPLE, CanonSIMPLE},
+	}
+	if len(sigs) != len(want) {
+		t.Fatal(sigs)
+	}
+	for i := range want {
+		if sigs[i] != want[i] {
+			t.Errorf("%d: %+v", i, sigs[i])
+		}
+	}
+
+	for _, msg := range []string{
+		"\tcontinuation first\r\n\r\n",
+		"no colon\r\n\r\n",
+	} {
+		if _, err := ParseSignatureHeaders([]byte(msg)); err == nil {
+			t.Errorf("%q: no error", msg)
+		}
+	}
+
+	if sigs, err := ParseSignatureHeaders([]byte("From: a@b.com\r\n\r\n")); err != nil || len(sigs) != 0 {
+		t.Fatal(sigs, err)
+	}
+}
@@ -0,0 +1,123 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"io"
+)
+
+// Signer signs messages with a fixed key and configuration. Unlike a
+// signing handle, it can sign any number of messages; each call to Sign
+// uses a fresh handle. Create it with Lib.Signer and release it with
+// Close.
+type Signer struct {
+	lib      *Lib
+	domain   string
+	selector string
+	key      []byte
+	cfg      signerConfig
+}
+
+type signerConfig struct {
+	hdrCanon  Canon
+	bodyCanon Canon
+	algo      Sign
+	bodyLen   int64
+	signHdrs  []string
+	oversign  []string
+}
+
+// SignerOption configures a Signer.
+type SignerOption func(*signerConfig)
+
+// WithHeaderCanon sets the header canonicalization. The default is
+// CanonRELAXED.
+func WithHeaderCanon(c Canon) SignerOption {
+	return func(cfg *signerConfig) { cfg.hdrCanon = c }
+}
+
+// WithBodyCanon sets the body canonicalization. The default is
+// CanonRELAXED.
+func WithBodyCanon(c Canon) SignerOption {
+	return func(cfg *signerConfig) { cfg.bodyCanon = c }
+}
+
+// WithAlgorithm sets the signing algorithm. The default is
+// SignRSASHA256.
+func WithAlgorithm(algo Sign) SignerOption {
+	return func(cfg *signerConfig) { cfg.algo = algo }
+}
+
+// WithBodyLengthLimit signs only the first n bytes of the canonicalized
+// body, see NewSigner. By default the whole body is signed.
+func WithBodyLengthLimit(n int64) SignerOption {
+	return func(cfg *signerConfig) { cfg.bodyLen = n }
+}
+
+// WithSignedHeaders sets the header fields to sign, see
+// Lib.SetSignHeaders.
+func WithSignedHeaders(hdrs ...string) SignerOption {
+	return func(cfg *signerConfig) { cfg.signHdrs = hdrs }
+}
+
+// WithOversign sets the header fields to oversign, see
+// Lib.SetOversignHeaders.
+func WithOversign(hdrs ...string) SignerOption {
+	return func(cfg *signerConfig) { cfg.oversign = hdrs }
+}
+
+// Signer creates a Signer for domain and selector with key, a PEM or DER
+// encoded private key as accepted by NewSignerBytes. The Signer has a
+// library handle of its own with the options, header lists and callbacks
+// of lib, so opts don't affect lib or other Signers; WithSignedHeaders and
+// WithOversign replace the lists set on lib.
+func (lib *Lib) Signer(domain, selector string, key []byte, opts ...SignerOption) (*Signer, Status) {
+	s := &Signer{
+		domain:   domain,
+		selector: selector,
+		key:      append([]byte(nil), key...),
+		cfg: signerConfig{
+			hdrCanon:  CanonRELAXED,
+			bodyCanon: CanonRELAXED,
+			algo:      SignRSASHA256,
+			bodyLen:   -1,
+		},
+	}
+	for _, opt := range opts {
+		opt(&s.cfg)
+	}
+
+	own, stat := lib.derive()
+	if stat != StatusOK {
+		return nil, stat
+	}
+	if s.cfg.signHdrs != nil {
+		stat = own.SetSignHeaders(s.cfg.signHdrs)
+	}
+	if s.cfg.oversign != nil && stat == StatusOK {
+		stat = own.SetOversignHeaders(s.cfg.oversign)
+	}
+	if stat != StatusOK {
+		own.Close()
+		return nil, stat
+	}
+	s.lib = own
+	return s, StatusOK
+}
+
+// Sign signs a message like Dkim.Sign and returns it with the signature
+// header added.
+func (s *Signer) Sign(r io.Reader) ([]byte, error) {
+	d, stat := s.lib.NewSignerBytes(s.key, s.selector, s.domain, s.cfg.hdrCanon, s.cfg.bodyCanon, s.cfg.algo, s.cfg.bodyLen)
+	if stat != StatusOK {
+		return nil, stat
+	}
+	defer d.Destroy()
+
+	return d.Sign(r)
+}
+
+// Close releases the library handle of the Signer.
+func (s *Signer) Close() {
+	s.lib.Close()
+}
@@ -0,0 +1,107 @@
+package opendkim
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSigner(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		opts  []SignerOption
+		check func(msg []byte) bool
+	}{
+		{"defaults", nil, func(msg []byte) bool {
+			return sigTag(msg, "c", t) == "relaxed/relaxed" && sigTag(msg, "a", t) == "rsa-sha256" && sigTag(msg, "l", t) == ""
+		}},
+		{"canon", []SignerOption{WithHeaderCanon(CanonSIMPLE), WithBodyCanon(CanonSIMPLE)}, func(msg []byte) bool {
+			return sigTag(msg, "c", t) == "simple/simple"
+		}},
+		{"algorithm", []SignerOption{WithAlgorithm(SignRSASHA1)}, func(msg []byte) bool {
+			return sigTag(msg, "a", t) == "rsa-sha1"
+		}},
+		{"length", []SignerOption{WithBodyLengthLimit(5)}, func(msg []byte) bool {
+			return sigTag(msg, "l", t) == "5"
+		}},
+		{"headers", []SignerOption{WithSignedHeaders("From", "Subject"), WithOversign("From")}, func(msg []byte) bool {
+			h := strings.ToLower(sigTag(msg, "h", t))
+			return strings.Count(h, "from") == 2 && strings.Contains(h, "subject") && !strings.Contains(h, "date")
+		}},
+	} {
+		lib := testLib(t)
+
+		s, stat := lib.Signer(domain, selector, []byte(testKey), tc.opts...)
+		if stat != StatusOK {
+			t.Fatal(tc.name, stat)
+		}
+		// a Signer can be used for several messages
+		for i := 0; i < 2; i++ {
+			out, err := s.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+			if err != nil {
+				t.Fatal(tc.name, err)
+			}
+			if !tc.check(out) {
+				t.Errorf("%s: unexpected signature %q", tc.name, out)
+			}
+			verify(lib, out, t).Destroy()
+		}
+		s.Close()
+		lib.Close()
+	}
+}
+
+func TestSignerOwnLib(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	s1, stat := lib.Signer(domain, selector, []byte(testKey), WithSignedHeaders("From", "Subject"))
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer s1.Close()
+
+	// another Signer doesn't reconfigure the first one
+	s2, stat := lib.Signer(domain, selector, []byte(testKey), WithSignedHeaders("From", "Date"), WithOversign("From"))
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer s2.Close()
+
+	out, err := s1.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := strings.ToLower(sigTag(out, "h", t)); strings.Count(h, "from") != 1 || strings.Contains(h, "date") {
+		t.Fatal(h)
+	}
+
+	// nor lib
+	if h := strings.ToLower(sigTag(sign(lib, createMsg(msgHdr, msgBody), t), "h", t)); strings.Count(h, "from") != 1 {
+		t.Fatal(h)
+	}
+}
+
+func TestSignerHeaderListsFromLib(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetOversignHeaders([]string{"From", "Subject"}); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	s, stat := lib.Signer(domain, selector, []byte(testKey))
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer s.Close()
+
+	out, err := s.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := strings.ToLower(sigTag(out, "h", t))
+	if strings.Count(h, "from") != 2 || strings.Count(h, "subject") != 2 {
+		t.Fatal(h)
+	}
+	verify(lib, out, t).Destroy()
+}
@@ -0,0 +1,160 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// SignerSpec describes one signature made by a SignerSet.
+type SignerSpec struct {
+	Key         string // private key, PEM encoded
+	Selector    string
+	Domain      string
+	HeaderCanon Canon
+	BodyCanon   Canon
+	Algorithm   Sign
+}
+
+// SignerSet is a set of independent signing handles that are fed the
+// same message, e.g. to add one signature per domain. It only saves the
+// caller from reading and passing the message several times: every
+// handle canonicalizes and hashes the header and body on its own, so the
+// cost grows with the number of signatures. (dkim_resign, see Resign,
+// shares canonicalized data only between one verifying and one signing
+// handle.)
+type SignerSet struct {
+	signers []*Dkim
+	failed  *Dkim // handle that failed last, for error details
+}
+
+// NewSignerSet creates a signing handle for each spec. The whole body
+// is signed.
+func (lib *Lib) NewSignerSet(specs []SignerSpec) (*SignerSet, Status) {
+	ss := &SignerSet{}
+	for _, s := range specs {
+		d, stat := lib.NewSigner(s.Key, s.Selector, s.Domain, s.HeaderCanon, s.BodyCanon, s.Algorithm, -1)
+		if stat != StatusOK {
+			ss.Destroy()
+			return nil, stat
+		}
+		ss.signers = append(ss.signers, d)
+	}
+	return ss, StatusOK
+}
+
+// Signers returns the signing handles in the order of the specs.
+func (ss *SignerSet) Signers() []*Dkim {
+	return ss.signers
+}
+
+// Header passes a header field to all signing handles.
+func (ss *SignerSet) Header(line string) Status {
+	return ss.each(func(d *Dkim) Status { return d.Header(line) })
+}
+
+// Eoh signals the end of header to all signing handles.
+func (ss *SignerSet) Eoh() Status {
+	return ss.each((*Dkim).Eoh)
+}
+
+// Body passes body data to all signing handles.
+func (ss *SignerSet) Body(data []byte) Status {
+	return ss.each(func(d *Dkim) Status { return d.Body(data) })
+}
+
+// Eom signals the end of message to all signing handles.
+func (ss *SignerSet) Eom() Status {
+	return ss.each(func(d *Dkim) Status { return d.Eom(nil) })
+}
+
+// GetSigHdrs returns the signature header values after Eom, in the order
+// of the specs.
+func (ss *SignerSet) GetSigHdrs() ([]string, Status) {
+	hdrs := make([]string, 0, len(ss.signers))
+	for _, d := range ss.signers {
+		h, stat := d.GetSigHdr()
+		if stat != StatusOK {
+			ss.failed = d
+			return nil, stat
+		}
+		hdrs = append(hdrs, h)
+	}
+	return hdrs, StatusOK
+}
+
+// Sign signs a message with every handle like Dkim.Sign, adding all
+// signature headers to it.
+func (ss *SignerSet) Sign(r io.Reader) ([]byte, error) {
+	br := bufio.NewReader(r)
+	fields, err := readHeader(br)
+	if err != nil {
+		return nil, Status(StatusINTERNAL)
+	}
+	var out bytes.Buffer
+	for _, h := range fields {
+		if err := ss.statusError(ss.Header(h)); err != nil {
+			return nil, err
+		}
+		out.WriteString(h + "\r\n")
+	}
+	if err := ss.statusError(ss.Eoh()); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	io.Copy(&body, br)
+	data := body.Bytes()
+	if len(ss.signers) > 0 && ss.signers[0].lib.Flags()&LibflagsFIXCRLF != 0 {
+		data = fixCRLF(data)
+	}
+	if err := ss.statusError(ss.Body(data)); err != nil {
+		return nil, err
+	}
+	if err := ss.statusError(ss.Eom()); err != nil {
+		return nil, err
+	}
+
+	hdrs, stat := ss.GetSigHdrs()
+	if err := ss.statusError(stat); err != nil {
+		return nil, err
+	}
+	for _, h := range hdrs {
+		out.WriteString(sigField(h))
+	}
+	out.WriteString("\r\n")
+	out.Write(data)
+	return out.Bytes(), nil
+}
+
+// Destroy destroys all signing handles.
+func (ss *SignerSet) Destroy() {
+	for _, d := range ss.signers {
+		d.Destroy()
+	}
+}
+
+// each calls fn for every signing handle and stops at the first failure.
+func (ss *SignerSet) each(fn func(*Dkim) Status) Status {
+	for _, d := range ss.signers {
+		if stat := fn(d); stat != StatusOK {
+			ss.failed = d
+			return stat
+		}
+	}
+	return StatusOK
+}
+
+// statusError returns stat as an error carrying the error detail of the
+// handle that failed, or nil if stat is StatusOK.
+func (ss *SignerSet) statusError(stat Status) error {
+	if stat == StatusOK {
+		return nil
+	}
+	if ss.failed == nil {
+		return stat
+	}
+	return ss.failed.statusError(stat)
+}
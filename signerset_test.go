@@ -0,0 +1,46 @@
+package opendkim
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSignerSet(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	ss, stat := lib.NewSignerSet([]SignerSpec{
+		{Key: testKey, Selector: selector, Domain: domain, HeaderCanon: CanonRELAXED, BodyCanon: CanonRELAXED, Algorithm: SignRSASHA256},
+		{Key: testKey, Selector: selector, Domain: "example.org", HeaderCanon: CanonSIMPLE, BodyCanon: CanonSIMPLE, Algorithm: SignRSASHA256},
+	})
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer ss.Destroy()
+
+	out, err := ss.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(out, []byte(sigHdrPrefix)); n != 2 {
+		t.Fatal(n)
+	}
+
+	d := verify(lib, out, t)
+	defer d.Destroy()
+
+	sigs, stat := d.GetSignatures()
+	if stat != StatusOK || len(sigs) != 2 {
+		t.Fatal(stat, len(sigs))
+	}
+	domains := map[string]bool{}
+	for _, sig := range sigs {
+		if !sig.Passed() || sig.BodyHashResult() != BodyHashMATCH {
+			t.Fatal(sig.Domain(), sig.Flags(), sig.BodyHashResult())
+		}
+		domains[sig.Domain()] = true
+	}
+	if !domains[domain] || !domains["example.org"] {
+		t.Fatal(domains)
+	}
+}
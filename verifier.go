@@ -0,0 +1,98 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"io"
+)
+
+// Verifier verifies messages with a fixed configuration, using a fresh
+// verifying handle for each message. Create it with Lib.Verifier and
+// release it with Close.
+type Verifier struct {
+	lib *Lib
+}
+
+type verifierConfig struct {
+	requiredHdrs []string
+	minKeyBits   int
+	verifyOne    bool
+	resolver     Resolver
+}
+
+// VerifierOption configures a Verifier.
+type VerifierOption func(*verifierConfig)
+
+// WithRequiredHeaders sets the header fields a signature has to cover,
+// see Lib.SetRequiredHeaders.
+func WithRequiredHeaders(hdrs ...string) VerifierOption {
+	return func(cfg *verifierConfig) { cfg.requiredHdrs = hdrs }
+}
+
+// WithMinKeyBits sets the minimum accepted key size, see
+// Lib.SetMinKeyBits.
+func WithMinKeyBits(bits int) VerifierOption {
+	return func(cfg *verifierConfig) { cfg.minKeyBits = bits }
+}
+
+// WithVerifyOne stops verification at the first passing signature, see
+// Lib.SetVerifyOne.
+func WithVerifyOne() VerifierOption {
+	return func(cfg *verifierConfig) { cfg.verifyOne = true }
+}
+
+// WithResolver looks up keys with r, see Lib.SetResolver.
+func WithResolver(r Resolver) VerifierOption {
+	return func(cfg *verifierConfig) { cfg.resolver = r }
+}
+
+// Verifier creates a Verifier with the options, header lists and callbacks
+// of lib. The Verifier has a library handle of its own, so opts don't
+// affect lib or other Verifiers; WithRequiredHeaders replaces a list set
+// with Lib.SetRequiredHeaders. With WithResolver, keys are looked up with
+// the resolver even if lib reads them from a file.
+func (lib *Lib) Verifier(opts ...VerifierOption) (*Verifier, Status) {
+	var cfg verifierConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	own, stat := lib.derive()
+	if stat != StatusOK {
+		return nil, stat
+	}
+	if cfg.requiredHdrs != nil {
+		stat = own.SetRequiredHeaders(cfg.requiredHdrs)
+	}
+	if cfg.minKeyBits > 0 && stat == StatusOK {
+		stat = own.SetMinKeyBits(cfg.minKeyBits)
+	}
+	if cfg.verifyOne && stat == StatusOK {
+		stat = own.SetVerifyOne(true)
+	}
+	if cfg.resolver != nil && stat == StatusOK {
+		stat = own.setQueryMethod(QueryDNS)
+		own.SetResolver(cfg.resolver)
+	}
+	if stat != StatusOK {
+		own.Close()
+		return nil, stat
+	}
+	return &Verifier{lib: own}, StatusOK
+}
+
+// Verify verifies a message like Dkim.VerifyDetailed.
+func (v *Verifier) Verify(r io.Reader) (*VerifyResult, error) {
+	d, stat := v.lib.NewVerifier()
+	if stat != StatusOK {
+		return nil, stat
+	}
+	defer d.Destroy()
+
+	return d.VerifyDetailed(r)
+}
+
+// Close releases the library handle of the Verifier.
+func (v *Verifier) Close() {
+	v.lib.Close()
+}
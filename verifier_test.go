@@ -0,0 +1,124 @@
+package opendkim
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	signer := testLib(t)
+	defer signer.Close()
+
+	s, stat := signer.Signer(domain, selector, []byte(testKey), WithSignedHeaders("From", "To", "Date"))
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer s.Close()
+
+	msg, err := s.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts []VerifierOption
+		pass bool
+	}{
+		{"defaults", nil, true},
+		{"verify one", []VerifierOption{WithVerifyOne(), WithMinKeyBits(1024)}, true},
+		{"resolver", []VerifierOption{WithResolver(newMapResolver(map[string]string{
+			selector + "._domainkey." + domain: testRecord(t),
+		}))}, true},
+		{"min key bits", []VerifierOption{WithMinKeyBits(4096)}, false},
+		{"required headers", []VerifierOption{WithRequiredHeaders("From", "Subject")}, false},
+	} {
+		lib := testLib(t)
+		if tc.name == "resolver" {
+			// don't read keys from the file
+			lib.Close()
+			lib = Init()
+		}
+
+		v, stat := lib.Verifier(tc.opts...)
+		if stat != StatusOK {
+			t.Fatal(tc.name, stat)
+		}
+		res, err := v.Verify(bytes.NewReader(msg))
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if (res.Status == StatusOK) != tc.pass {
+			t.Errorf("%s: %v", tc.name, res.Status)
+		}
+		if len(res.Signatures) != 1 || (res.Signatures[0].Flags&SigflagPASSED != 0) != tc.pass {
+			t.Errorf("%s: %+v", tc.name, res.Signatures)
+		}
+		v.Close()
+		lib.Close()
+	}
+}
+
+func TestVerifierOwnLib(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	strict, stat := lib.Verifier(WithVerifyOne(), WithMinKeyBits(4096))
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer strict.Close()
+
+	// options of one Verifier don't leak into lib or later Verifiers
+	if lib.Flags()&LibflagsVERIFYONE != 0 {
+		t.Fatal(lib.Flags())
+	}
+	v, stat := lib.Verifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer v.Close()
+
+	if v.lib.Flags()&LibflagsVERIFYONE != 0 {
+		t.Fatal(v.lib.Flags())
+	}
+	res, err := v.Verify(bytes.NewReader(msg))
+	if err != nil || res.Status != StatusOK {
+		t.Fatal(res, err)
+	}
+	verify(lib, msg, t).Destroy()
+
+	if res, err = strict.Verify(bytes.NewReader(msg)); err != nil || res.Status == StatusOK {
+		t.Fatal(res, err)
+	}
+}
+
+func TestVerifierHeaderListsFromLib(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetSignHeaders([]string{"From", "To", "Date"}); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	// Subject isn't signed, so the list set on lib makes the Verifier fail
+	if stat := lib.SetRequiredHeaders([]string{"From", "Subject"}); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	v, stat := lib.Verifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer v.Close()
+
+	res, err := v.Verify(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status == StatusOK {
+		t.Fatal(res.Status)
+	}
+}
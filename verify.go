@@ -0,0 +1,305 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strings"
+)
+
+// VerifyResult is the outcome of verifying a message.
+type VerifyResult struct {
+	Status     Status             // overall status, as returned by Verify
+	FromDomain string             // domain of the From header, for alignment
+	Signatures []*SignatureResult // all signatures found on the message
+	Index      int                // position of the message in a batch, see VerifyBatch
+
+	// Err is nil if Status is StatusOK, and otherwise a *StatusError
+	// carrying the handle's error detail.
+	Err error
+}
+
+// SignatureResult describes a single signature of a verified message.
+type SignatureResult struct {
+	Domain   string
+	Selector string
+	Flags    Sigflag
+	BodyHash BodyHash
+	KeySize  int // 0 if the key couldn't be loaded
+}
+
+// VerifyDetailed verifies a message in one step like Verify, but returns
+// the details of every signature along with the overall status.
+// Verification outcomes, like a bad signature or a missing key, are
+// reported in the result. An error is returned instead if r can't be read
+// or the library fails to process the message, e.g. with StatusSYNTAX for
+// a malformed header field or StatusNORESOURCE.
+func (d *Dkim) VerifyDetailed(r io.Reader) (*VerifyResult, error) {
+	fields, body, err := d.readMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading message: %w", err)
+	}
+	_, _, stat := d.feed(fields, body, func() Status { return d.Eom(nil) })
+	if !verifyOutcome(stat) {
+		return nil, d.statusError(stat)
+	}
+	res := &VerifyResult{
+		Status:     stat,
+		FromDomain: addrDomain(d.fromValue()),
+		Err:        d.statusError(stat),
+	}
+
+	sigs, _ := d.GetSignatures()
+	for _, sig := range sigs {
+		bits, _ := sig.KeySize()
+		res.Signatures = append(res.Signatures, &SignatureResult{
+			Domain:   sig.Domain(),
+			Selector: sig.Selector(),
+			Flags:    sig.Flags(),
+			BodyHash: sig.BodyHashResult(),
+			KeySize:  bits,
+		})
+	}
+	return res, nil
+}
+
+// VerifyBytes verifies a message like VerifyDetailed, but first checks
+// that it parses with net/mail. If it doesn't, the net/mail error is
+// returned, wrapped, and the message isn't passed to the library, so a
+// malformed message can be told apart from one that failed verification,
+// which is reported in the result.
+func (d *Dkim) VerifyBytes(raw []byte) (*VerifyResult, error) {
+	if _, err := mail.ReadMessage(bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("malformed message: %w", err)
+	}
+	return d.VerifyDetailed(bytes.NewReader(raw))
+}
+
+// VerifyWithKey verifies a message like VerifyBytes, but uses record,
+// in the format of a key TXT record, as the key of every signature
+// instead of looking keys up, e.g. for tests or air-gapped verification.
+// The message is verified with a library handle of its own that has the
+// options, header lists and callbacks of lib, like the minimum key size,
+// except for the key lookup, so lib and its other handles are left alone.
+func (lib *Lib) VerifyWithKey(raw []byte, record string) (*VerifyResult, error) {
+	priv, stat := lib.derive()
+	if stat != StatusOK {
+		return nil, stat
+	}
+	defer priv.Close()
+
+	key := []byte(record)
+	stat = priv.SetKeyLookup(func(*Signature, string, string) ([]byte, Status) {
+		return key, StatusOK
+	})
+	if stat != StatusOK {
+		return nil, stat
+	}
+	d, stat := priv.NewVerifier()
+	if stat != StatusOK {
+		return nil, stat
+	}
+	defer d.Destroy()
+
+	return d.VerifyBytes(raw)
+}
+
+// verifyOutcome reports whether stat, as returned by a verifying handle,
+// is the outcome of verification rather than a failure to process the
+// message.
+func verifyOutcome(stat Status) bool {
+	switch stat {
+	case StatusOK, StatusBADSIG, StatusNOSIG, StatusNOKEY, StatusCANTVRFY,
+		StatusREVOKED, StatusKEYFAIL, StatusCBREJECT, StatusCBTRYAGAIN,
+		StatusMULTIDNSREPLY:
+		return true
+	}
+	return false
+}
+
+// addrDomain returns the lowercased domain of the address in a header
+// value as parsed by libopendkim, or an empty string.
+func addrDomain(v string) string {
+	_, domain, err := MailParse(v)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(domain)
+}
+
+var (
+	errNoFrom       = errors.New("no From header")
+	errMultipleFrom = errors.New("multiple From addresses")
+)
+
+// FromDomain returns the lowercased domain of the address in the From
+// header passed to the handle, parsed with MailParse like the library
+// parses it. An error is returned if there is no From header, if it
+// can't be parsed, or if the message has more than one From address,
+// either in a single field or in repeated fields, as there is no single
+// domain to align with then.
+func (d *Dkim) FromDomain() (string, error) {
+	d.mtx.Lock()
+	from, n := d.from, d.nfrom
+	d.mtx.Unlock()
+
+	switch {
+	case n == 0:
+		return "", errNoFrom
+	case n > 1:
+		return "", errMultipleFrom
+	}
+	if addrs, err := mail.ParseAddressList(from); err == nil && len(addrs) > 1 {
+		return "", errMultipleFrom
+	}
+	_, domain, err := MailParse(from)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(domain), nil
+}
+
+// fromValue returns the value of the first From header.
+func (d *Dkim) fromValue() string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.from
+}
+
+// AlignMode is a DMARC identifier alignment mode.
+type AlignMode int
+
+const (
+	AlignRelaxed AlignMode = iota // organizational domains must match
+	AlignStrict                   // domains must match exactly
+)
+
+// AlignedDomains returns the signing domains of the passing signatures
+// that are aligned with the From domain from, e.g. VerifyResult.FromDomain.
+//
+// For relaxed alignment, orgDomain returns the organizational domain of a
+// domain, e.g. a function based on golang.org/x/net/publicsuffix. If it
+// is nil, the organizational domain can't be told, so domains are only
+// aligned if they are equal or one is a subdomain of the other.
+func (d *Dkim) AlignedDomains(from string, mode AlignMode, orgDomain func(domain string) string) []string {
+	from = normDomain(from)
+	if from == "" {
+		return nil
+	}
+
+	var res []string
+	sigs, _ := d.GetSignatures()
+	for _, sig := range sigs {
+		if sig.Flags()&SigflagPASSED == 0 || sig.BodyHashResult() != BodyHashMATCH {
+			continue
+		}
+		dom := normDomain(sig.Domain())
+		if !aligned(dom, from, mode, orgDomain) {
+			continue
+		}
+		dup := false
+		for _, r := range res {
+			dup = dup || r == dom
+		}
+		if !dup {
+			res = append(res, dom)
+		}
+	}
+	return res
+}
+
+func aligned(a, b string, mode AlignMode, orgDomain func(string) string) bool {
+	if a == b {
+		return true
+	}
+	if mode != AlignRelaxed {
+		return false
+	}
+	if orgDomain == nil {
+		return strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+	}
+	org := orgDomain(a)
+	return org != "" && org == orgDomain(b)
+}
+
+func normDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
+
+// AuthenticationResults formats the DKIM results of a verified message as
+// the value of an Authentication-Results header field (RFC 8601), e.g.
+//
+//	mx.example.org;
+//		dkim=pass (2048-bit key) header.d=example.org header.i=@example.org header.s=sel header.b=abcdefgh
+//
+// authservID identifies the verifying host. Messages without signatures
+// result in dkim=none.
+func (d *Dkim) AuthenticationResults(authservID string) (string, Status) {
+	sigs, stat := d.GetSignatures()
+	if stat != StatusOK {
+		return "", stat
+	}
+
+	var results []string
+	for _, sig := range sigs {
+		if sig.Flags()&SigflagIGNORE != 0 {
+			continue
+		}
+		results = append(results, authResult(sig))
+	}
+	if len(results) == 0 {
+		return authservID + "; dkim=none", stat
+	}
+	return authservID + ";\r\n\t" + strings.Join(results, ";\r\n\t"), stat
+}
+
+// authResult formats the result of a single signature.
+func authResult(sig *Signature) string {
+	flags := sig.Flags()
+
+	var res, comment string
+	switch err := sig.Err(); {
+	case sig.dnssecRejected():
+		res, comment = "policy", "insecure key"
+	case flags&SigflagPASSED != 0 && sig.BodyHashResult() == BodyHashMATCH:
+		res = "pass"
+		if bits, stat := sig.KeySize(); stat == StatusOK {
+			comment = fmt.Sprintf("%d-bit key", bits)
+		}
+	case flags&SigflagPROCESSED == 0:
+		res = "neutral"
+	case err == SigErrorKEYFAIL:
+		res, comment = "temperror", sig.ErrString()
+	case err == SigErrorBADSIG || err == SigErrorOK:
+		res = "fail"
+		if sig.BodyHashResult() == BodyHashMISMATCH {
+			comment = "body hash mismatch"
+		} else {
+			comment = sig.ErrString()
+		}
+	default:
+		res, comment = "permerror", sig.ErrString()
+	}
+
+	b := []string{"dkim=" + res}
+	if comment != "" {
+		b = append(b, "("+comment+")")
+	}
+	b = append(b, "header.d="+sig.Domain())
+	if id := sig.Identity(); id != "" {
+		b = append(b, "header.i="+id)
+	}
+	b = append(b, "header.s="+sig.Selector())
+	if v, ok := sig.TagValue("b"); ok {
+		v = strings.Join(strings.Fields(v), "")
+		if len(v) > 8 {
+			v = v[:8]
+		}
+		b = append(b, "header.b="+v)
+	}
+	return strings.Join(b, " ")
+}
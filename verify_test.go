@@ -0,0 +1,377 @@
+package opendkim
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestVerifyDetailed(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	res, err := d.VerifyDetailed(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusOK {
+		t.Fatal(res.Status)
+	}
+	if res.FromDomain != "b.com" {
+		t.Fatal(res.FromDomain)
+	}
+	if len(res.Signatures) != 1 {
+		t.Fatal(len(res.Signatures))
+	}
+	sig := res.Signatures[0]
+	if sig.Domain != domain {
+		t.Fatal(sig.Domain)
+	}
+	if sig.Selector != selector {
+		t.Fatal(sig.Selector)
+	}
+	if sig.Flags&SigflagPASSED == 0 {
+		t.Fatal(sig.Flags)
+	}
+	if sig.BodyHash != BodyHashMATCH {
+		t.Fatal(sig.BodyHash)
+	}
+	if sig.KeySize != 2048 {
+		t.Fatal(sig.KeySize)
+	}
+}
+
+func TestVerifyDetailedReadError(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+	errRead := errors.New("connection reset")
+
+	for name, r := range map[string]io.Reader{
+		"header": io.MultiReader(bytes.NewReader(out[:bytes.Index(out, []byte("Subject:"))]), iotest.ErrReader(errRead)),
+		"body":   io.MultiReader(bytes.NewReader(out[:len(out)-4]), iotest.ErrReader(errRead)),
+	} {
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		res, err := d.VerifyDetailed(r)
+		d.Destroy()
+		if res != nil || !errors.Is(err, errRead) {
+			t.Fatal(name, res, err)
+		}
+	}
+}
+
+func TestVerifyDetailedMalformedHeader(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	res, err := d.VerifyDetailed(strings.NewReader("From: a@b.com\r\nno colon\r\n\r\nbody\r\n"))
+	if res != nil || !errors.Is(err, errMalformedHeader) {
+		t.Fatal(res, err)
+	}
+}
+
+func TestVerifyBytes(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+		want Status
+	}{
+		{"signed", msg, StatusOK},
+		{"tampered", append(msg[:len(msg):len(msg)], "tampered\r\n"...), StatusBADSIG},
+	} {
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		res, err := d.VerifyBytes(tc.msg)
+		if err != nil {
+			t.Fatal(tc.name, err)
+		}
+		if res.Status != tc.want {
+			t.Error(tc.name, res.Status)
+		}
+		d.Destroy()
+	}
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	// cut off in the middle of a header field
+	truncated := msg[:bytes.Index(msg, []byte("Subject:"))+4]
+	res, err := d.VerifyBytes(truncated)
+	if res != nil || err == nil {
+		t.Fatal(res, err)
+	}
+	if !strings.HasPrefix(err.Error(), "malformed message: ") || errors.Unwrap(err) == nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyWithKey(t *testing.T) {
+	// no key file and no resolver, so keys can't be looked up
+	lib := Init()
+	defer lib.Close()
+
+	msg := sign(lib, createMsg(msgHdr, msgBody), t)
+
+	res, err := lib.VerifyWithKey(msg, testRecord(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != StatusOK || len(res.Signatures) != 1 || res.Signatures[0].KeySize != 2048 {
+		t.Fatal(res.Status, res.Signatures)
+	}
+
+	// a different key doesn't verify
+	_, txt, err := GenerateKey(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = lib.VerifyWithKey(msg, unquoteTXT(txt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status == StatusOK {
+		t.Fatal(res.Status)
+	}
+
+	// the usual key lookup of a library is left alone
+	lib = testLib(t)
+	defer lib.Close()
+
+	msg = sign(lib, createMsg(msgHdr, msgBody), t)
+	if _, err := lib.VerifyWithKey(msg, unquoteTXT(txt)); err != nil {
+		t.Fatal(err)
+	}
+	verify(lib, msg, t).Destroy()
+
+	// but its options apply, so a key below the minimum size is rejected
+	priv, txt, err := GenerateKey(1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, stat := lib.NewSigner(string(priv), selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	msg, err = d.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err = lib.VerifyWithKey(msg, unquoteTXT(txt)); err != nil || res.Status != StatusOK {
+		t.Fatal(res, err)
+	}
+	lib.SetMinKeyBits(2048)
+	if res, err = lib.VerifyWithKey(msg, unquoteTXT(txt)); err != nil || res.Status == StatusOK {
+		t.Fatal(res, err)
+	}
+}
+
+func TestFromDomain(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	for _, tc := range []struct {
+		hdr    string
+		domain string
+		err    error
+	}{
+		{"From: a@B.com\r\n", "b.com", nil},
+		{"From: \"Doe, John\" <john@Example.COM>\r\n", "example.com", nil},
+		{"From: John Doe (work) <john@mail.example.com>\r\n", "mail.example.com", nil},
+		{"FROM:\r\n  Jane <jane@example.org>\r\n", "example.org", nil},
+		{"From: a@b.com, c@d.com\r\n", "", errMultipleFrom},
+		{"From: a@b.com\r\nFrom: c@d.com\r\n", "", errMultipleFrom},
+		{"To: a@b.com\r\n", "", errNoFrom},
+	} {
+		d, stat := lib.NewVerifier()
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		d.Verify(strings.NewReader(tc.hdr + "Subject: test\r\n\r\nbody\r\n"))
+
+		domain, err := d.FromDomain()
+		if domain != tc.domain || err != tc.err {
+			t.Errorf("%q: got %q, %v", tc.hdr, domain, err)
+		}
+		d.Destroy()
+	}
+}
+
+func TestAlignedDomains(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d := verify(lib, sign(lib, createMsg(msgHdr, msgBody), t), t)
+	defer d.Destroy()
+
+	for _, tc := range []struct {
+		from string
+		mode AlignMode
+		want string
+	}{
+		{"erikk.org", AlignStrict, domain},
+		{"ERIKK.org.", AlignStrict, domain},
+		{"mail.erikk.org", AlignStrict, ""},
+		{"mail.erikk.org", AlignRelaxed, domain},
+		{"b.com", AlignRelaxed, ""},
+	} {
+		res := d.AlignedDomains(tc.from, tc.mode, nil)
+		if (tc.want == "" && len(res) != 0) || (tc.want != "" && (len(res) != 1 || res[0] != tc.want)) {
+			t.Errorf("%s %d: %v", tc.from, tc.mode, res)
+		}
+	}
+
+	// organizational domain from a Public Suffix List aware function
+	exact := func(domain string) string { return domain }
+	if res := d.AlignedDomains("mail.erikk.org", AlignRelaxed, exact); len(res) != 0 {
+		t.Fatal(res)
+	}
+	org := func(domain string) string {
+		labels := strings.Split(domain, ".")
+		return strings.Join(labels[len(labels)-2:], ".")
+	}
+	if res := d.AlignedDomains("www.mail.erikk.org", AlignRelaxed, org); len(res) != 1 {
+		t.Fatal(res)
+	}
+}
+
+func TestAligned(t *testing.T) {
+	psl := func(domain string) string {
+		if strings.HasSuffix(domain, ".co.uk") {
+			labels := strings.Split(domain, ".")
+			return strings.Join(labels[len(labels)-3:], ".")
+		}
+		return domain
+	}
+	for _, tc := range []struct {
+		a, b      string
+		orgDomain func(string) string
+		want      bool
+	}{
+		{"bank.co.uk", "bank.co.uk", nil, true},
+		{"mail.bank.co.uk", "bank.co.uk", nil, true},
+		{"bank.co.uk", "mail.bank.co.uk", nil, true},
+		{"evil.co.uk", "bank.co.uk", nil, false},
+		{"mail.bank.co.uk", "www.bank.co.uk", nil, false},
+		{"mail.bank.co.uk", "www.bank.co.uk", psl, true},
+		{"evil.co.uk", "bank.co.uk", psl, false},
+	} {
+		if x := aligned(tc.a, tc.b, AlignRelaxed, tc.orgDomain); x != tc.want {
+			t.Errorf("%s %s: %v", tc.a, tc.b, x)
+		}
+	}
+	if aligned("mail.bank.co.uk", "bank.co.uk", AlignStrict, nil) {
+		t.Fatal("strict")
+	}
+}
+
+func TestAuthenticationResults(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	out := sign(lib, createMsg(msgHdr, msgBody), t)
+	d := verify(lib, out, t)
+	defer d.Destroy()
+
+	ar, stat := d.AuthenticationResults("mx.b.com")
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	want := "mx.b.com;\r\n\tdkim=pass (2048-bit key) header.d=" + domain + " header.i=@" + domain +
+		" header.s=" + selector + " header.b=" + strings.Join(strings.Fields(sigTag(out, "b", t)), "")[:8]
+	if ar != want {
+		t.Fatalf("%q != %q", ar, want)
+	}
+
+	d, stat = lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	d.Verify(bytes.NewReader(append(out, "tampered\r\n"...)))
+	ar, stat = d.AuthenticationResults("mx.b.com")
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	if !strings.HasPrefix(ar, "mx.b.com;\r\n\tdkim=fail (body hash mismatch) header.d="+domain) {
+		t.Fatal(ar)
+	}
+
+	d, stat = lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	d.Verify(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	ar, stat = d.AuthenticationResults("mx.b.com")
+	if stat != StatusOK || ar != "mx.b.com; dkim=none" {
+		t.Fatal(ar, stat)
+	}
+}
+
+func TestVerifyDetailedError(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	signer, stat := lib.NewSigner(testKey, "nokey", domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer signer.Destroy()
+
+	out, err := signer.Sign(bytes.NewReader(createMsg(msgHdr, msgBody)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, stat := lib.NewVerifier()
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	res, err := d.VerifyDetailed(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status == StatusOK || res.Err == nil || !errors.Is(res.Err, res.Status) {
+		t.Fatal(res.Status, res.Err)
+	}
+	if !strings.Contains(res.Err.Error(), "nokey._domainkey."+domain) {
+		t.Fatal(res.Err)
+	}
+}
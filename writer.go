@@ -0,0 +1,244 @@
+// +build !windows
+
+package opendkim
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+// SignWriter passes a message written to it to a signing handle, so it
+// can be signed while it is streamed, e.g. with io.Copy. See
+// Dkim.SignWriter.
+type SignWriter struct {
+	d      *Dkim
+	hdr    bytes.Buffer // header data until the end of header is seen
+	inBody bool
+	sig    string
+	err    error
+}
+
+// SignWriter returns a writer signing the message written to it with d.
+// The message is written as is, header and body separated by an empty
+// line. The signature is computed by Close.
+func (d *Dkim) SignWriter() (*SignWriter, error) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+
+	if d.dkim == nil {
+		return nil, Status(StatusINVALID)
+	}
+	return &SignWriter{d: d}, nil
+}
+
+// Write passes message data to the signing handle. Header data is
+// buffered until the end of header, body data is passed on directly.
+func (w *SignWriter) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.inBody {
+		if stat := w.d.Body(p); stat != StatusOK {
+			w.err = w.d.statusError(stat)
+			return 0, w.err
+		}
+		return len(p), nil
+	}
+
+	w.hdr.Write(p)
+	i := headerEnd(w.hdr.Bytes())
+	if i < 0 {
+		return len(p), nil
+	}
+	body := append([]byte(nil), w.hdr.Bytes()[i:]...)
+	w.hdr.Truncate(i)
+	if err := w.endHeader(); err != nil {
+		return 0, err
+	}
+	if len(body) > 0 {
+		if stat := w.d.Body(body); stat != StatusOK {
+			w.err = w.d.statusError(stat)
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+func (w *SignWriter) endHeader() error {
+	w.inBody = true
+	if _, stat := w.d.processHeader(bufio.NewReader(&w.hdr)); stat != StatusOK {
+		w.err = w.d.statusError(stat)
+	}
+	return w.err
+}
+
+// Close finishes the message and computes the signature.
+func (w *SignWriter) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if !w.inBody {
+		if err := w.endHeader(); err != nil {
+			return err
+		}
+	}
+	if stat := w.d.Eom(nil); stat != StatusOK {
+		w.err = w.d.statusError(stat)
+		return w.err
+	}
+	sig, stat := w.d.GetSigHdr()
+	if stat != StatusOK {
+		w.err = w.d.statusError(stat)
+		return w.err
+	}
+	w.sig = sig
+	return nil
+}
+
+// Signature returns the value of the DKIM-Signature header computed by
+// Close, without the header name.
+func (w *SignWriter) Signature() string {
+	return w.sig
+}
+
+// SignReader passes a message through unchanged while signing it, see
+// Dkim.SignReader.
+type SignReader struct {
+	src io.Reader
+	w   *SignWriter
+	err error
+}
+
+// SignReader returns a reader that reads the message from src and signs
+// it with d as it is read, e.g. by a proxy forwarding the message as it
+// arrives. The data read is the message as is. Once src is exhausted, the
+// signature is computed and available from Signature; if signing fails,
+// the error is returned by Read in place of io.EOF.
+func (d *Dkim) SignReader(src io.Reader) *SignReader {
+	w, err := d.SignWriter()
+	return &SignReader{src: src, w: w, err: err}
+}
+
+// Read reads message data from the source and passes it to the signing
+// handle.
+func (r *SignReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err := r.src.Read(p)
+	if n > 0 {
+		if _, werr := r.w.Write(p[:n]); werr != nil {
+			r.err = werr
+			return n, werr
+		}
+	}
+	if err == io.EOF {
+		if cerr := r.w.Close(); cerr != nil {
+			r.err = cerr
+			return n, cerr
+		}
+	}
+	if err != nil {
+		r.err = err
+	}
+	return n, err
+}
+
+// Signature returns the value of the DKIM-Signature header, without the
+// header name, once the message has been read to the end.
+func (r *SignReader) Signature() string {
+	if r.w == nil {
+		return ""
+	}
+	return r.w.Signature()
+}
+
+// SignTo signs the message read from r like Sign, but writes the signed
+// message to w instead of returning it. The output is the same as Sign's.
+// As the signature header precedes the body, the body is spooled to a
+// temporary file while it is signed, so memory use doesn't grow with the
+// message size.
+func (d *Dkim) SignTo(r io.Reader, w io.Writer) error {
+	br := bufio.NewReader(r)
+	hdr, stat := d.processHeader(br)
+	if stat != StatusOK {
+		return d.statusError(stat)
+	}
+
+	spool, err := os.CreateTemp("", "opendkim-body-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(spool.Name())
+	defer spool.Close()
+
+	fix := d.lib != nil && d.lib.Flags()&LibflagsFIXCRLF != 0
+	buf := make([]byte, bodyChunkSize)
+	var carry []byte
+	for {
+		n, rerr := io.ReadFull(br, buf)
+		data := append(carry, buf[:n]...)
+		carry = nil
+		done := rerr == io.EOF || rerr == io.ErrUnexpectedEOF
+		if rerr != nil && !done {
+			return rerr
+		}
+		if fix {
+			// keep a trailing CR back, as its LF may be in the next chunk
+			if !done && len(data) > 0 && data[len(data)-1] == '\r' {
+				carry = []byte{'\r'}
+				data = data[:len(data)-1]
+			}
+			data = fixCRLF(data)
+		}
+		if len(data) > 0 {
+			if _, err := spool.Write(data); err != nil {
+				return err
+			}
+			if stat := d.Body(data); stat != StatusOK {
+				return d.statusError(stat)
+			}
+		}
+		if done {
+			break
+		}
+	}
+	if stat := d.Eom(nil); stat != StatusOK {
+		return d.statusError(stat)
+	}
+	sigHdr, stat := d.GetSigHdr()
+	if stat != StatusOK {
+		return d.statusError(stat)
+	}
+
+	hdr.WriteString(sigField(sigHdr) + "\r\n")
+	if _, err := hdr.WriteTo(w); err != nil {
+		return err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, spool)
+	return err
+}
+
+// headerEnd returns the offset of the body in a message, i.e. the end of
+// the empty line terminating the header, or -1 if it hasn't been seen yet.
+func headerEnd(msg []byte) int {
+	switch {
+	case bytes.HasPrefix(msg, []byte("\r\n")):
+		return 2
+	case bytes.HasPrefix(msg, []byte("\n")):
+		return 1
+	}
+	end := -1
+	if i := bytes.Index(msg, []byte("\n\r\n")); i >= 0 {
+		end = i + 3
+	}
+	if i := bytes.Index(msg, []byte("\n\n")); i >= 0 && (end < 0 || i+2 < end) {
+		end = i + 2
+	}
+	return end
+}
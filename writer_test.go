@@ -0,0 +1,129 @@
+package opendkim
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestSignWriter(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	w, err := d.SignWriter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := createMsg(msgHdr, msgBody)
+	// write in small pieces, so the end of header is split across writes
+	if _, err := io.Copy(w, iotest.OneByteReader(bytes.NewReader(msg))); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if w.Signature() == "" {
+		t.Fatal()
+	}
+
+	signed := append([]byte(sigHdrPrefix+w.Signature()+"\r\n"), msg...)
+	verify(lib, signed, t).Destroy()
+}
+
+func TestSignReader(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	// pin t=, so signatures of the same message are identical
+	if stat := lib.SetFixedTime(time.Unix(1362325420, 0)); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	msg := createMsg(msgHdr, msgBody)
+
+	d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+	if stat != StatusOK {
+		t.Fatal(stat)
+	}
+	defer d.Destroy()
+
+	r := d.SignReader(iotest.OneByteReader(bytes.NewReader(msg)))
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, msg) {
+		t.Fatalf("%q", out)
+	}
+
+	// a handle signing in one go must come up with the same signature
+	want := sigTag(sign(lib, msg, t), "b", t)
+	signed := append([]byte(sigHdrPrefix+r.Signature()+"\r\n"), msg...)
+	if got := sigTag(signed, "b", t); got != want {
+		t.Fatal(got, want)
+	}
+	verify(lib, signed, t).Destroy()
+}
+
+func TestSignTo(t *testing.T) {
+	lib := testLib(t)
+	defer lib.Close()
+
+	if stat := lib.SetFixedTime(time.Unix(1362325420, 0)); stat != StatusOK {
+		t.Fatal(stat)
+	}
+	big := strings.Repeat("a line of body text\r\n", 2*bodyChunkSize/21)
+
+	for _, tc := range []struct {
+		name string
+		msg  []byte
+		fix  bool
+	}{
+		{"small", createMsg(msgHdr, msgBody), false},
+		{"large", createMsg(msgHdr, big), false},
+		{"bare LF", createMsg(msgHdr, strings.ReplaceAll(big, "\r\n", "\n")), true},
+		// a CRLF split across chunks mustn't be fixed twice
+		{"split CRLF", createMsg(msgHdr, strings.Repeat("x", bodyChunkSize-1)+"\r\n"+big), true},
+	} {
+		lib.SetFixCRLF(tc.fix)
+
+		d, stat := lib.NewSigner(testKey, selector, domain, CanonRELAXED, CanonRELAXED, SignRSASHA256, -1)
+		if stat != StatusOK {
+			t.Fatal(stat)
+		}
+		var out bytes.Buffer
+		if err := d.SignTo(bytes.NewReader(tc.msg), &out); err != nil {
+			t.Fatal(tc.name, err)
+		}
+		d.Destroy()
+
+		if want := sign(lib, tc.msg, t); !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%s: output differs from Sign", tc.name)
+		}
+		verify(lib, out.Bytes(), t).Destroy()
+	}
+}
+
+func TestHeaderEnd(t *testing.T) {
+	for msg, want := range map[string]int{
+		"":                     -1,
+		"A: b\r\n":             -1,
+		"\r\nbody":             2,
+		"A: b\r\n\r\nbody":     8,
+		"A: b\n\nbody":         6,
+		"A: b\n\nbody\r\n\r\n": 6,
+		"A: b\r\n\r\nbody\n\n": 8,
+	} {
+		if i := headerEnd([]byte(msg)); i != want {
+			t.Errorf("%q: %d != %d", msg, i, want)
+		}
+	}
+}